@@ -1,30 +1,28 @@
 module github.com/robotsail/go-create-test
 
-go 1.20
+go 1.21
 
 require (
+	github.com/briandowns/spinner v1.23.0
+	github.com/cheggaaa/pb/v3 v3.1.2
+	github.com/mattn/go-isatty v0.0.17
 	github.com/smacker/go-tree-sitter v0.0.0-20230328150314-b02ac7b4e86d
 	github.com/spf13/cobra v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
-	github.com/briandowns/spinner v1.23.0 // indirect
-	github.com/cheggaaa/pb/v3 v3.1.2 // indirect
 	github.com/fatih/color v1.14.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
-	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/term v0.6.0 // indirect
 )
 
 require (
-	github.com/cheggaaa/pb v1.0.29
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/sashabaranov/go-openai v1.7.0
-	github.com/schollz/progressbar/v3 v3.13.1
 	github.com/spf13/pflag v1.0.5 // indirect
 )
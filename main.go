@@ -1,27 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/robotsail/go-create-test/pkg/cmd"
 )
 
-var requiredEnvs []string = []string{"OPENAI_API_KEY"}
-
-func checkEnv() {
-	for _, env := range requiredEnvs {
-		if _, ok := os.LookupEnv(env); !ok {
-			fmt.Printf("Missing required environment variable: %s", env)
-			os.Exit(1)
-		}
-	}
-}
-
 func main() {
-	checkEnv()
-	rootCmd := cmd.NewGenerateTestCmd()
-	if err := rootCmd.Execute(); err != nil {
+	// Ctrl-C stops in-flight requests to the model instead of killing the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rootCmd := cmd.NewRootCmd()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	} else {
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dirName is the directory of the cache within the user's cache directory.
+const dirName = "go-create-test"
+
+// Entry is a cached response of the model.
+type Entry struct {
+	Model            string    `json:"model"`
+	Response         string    `json:"response"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	Created          time.Time `json:"created"`
+}
+
+// Cache stores responses of the model on disk, keyed by a hash of everything the response depends on.
+type Cache struct {
+	dir string
+}
+
+// DefaultDir returns the cache directory, $XDG_CACHE_HOME/go-create-test on Linux.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the cache directory: %w", err)
+	}
+	return filepath.Join(dir, dirName), nil
+}
+
+// Open returns the cache stored in dir, creating the directory if needed.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Key hashes the parts into a key; parts are length-prefixed so that they cannot run into each other.
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get returns the entry stored under the key, and whether there was one.
+// Unreadable entries are treated as missing.
+func (c *Cache) Get(key string) (Entry, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return Entry{}, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Put stores the entry under the key, replacing any previous one.
+func (c *Cache) Put(key string, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create cache directory: %w", err)
+	}
+	// write to a temporary file first, so that concurrent readers never see a partial entry
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not write cache entry: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write cache entry: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write cache entry: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("could not write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Prune removes the entries last written before the given time, and returns how many were removed.
+func (c *Cache) Prune(before time.Time) (int, error) {
+	removed := 0
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") || !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("could not prune cache: %w", err)
+	}
+	return removed, nil
+}
+
+// path returns the file of an entry, spread over subdirectories by the first characters of the key.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
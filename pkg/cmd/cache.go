@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robotsail/go-create-test/pkg/cache"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagNoCache   = "no-cache"
+	FlagOlderThan = "older-than"
+	FlagAll       = "all"
+)
+
+// defaultPruneAge is the age of the cached responses removed by 'cache prune'.
+const defaultPruneAge = 30 * 24 * time.Hour
+
+// addCacheFlags registers the flags for commands which send requests to the model.
+func addCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool(FlagNoCache, false, "always send requests to the model, without using or storing cached responses")
+}
+
+// openCache opens the response cache, unless it is disabled through the flags.
+// A cache which cannot be opened is reported and not used.
+func openCache(opts GenerateTestsOptions) *cache.Cache {
+	if opts.NoCache {
+		return nil
+	}
+	dir, err := cache.DefaultDir()
+	if err == nil {
+		var c *cache.Cache
+		c, err = cache.Open(dir)
+		if err == nil {
+			return c
+		}
+	}
+	opts.Logger.Warn("not caching responses", "error", err)
+	return nil
+}
+
+func NewCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache of responses of the model",
+	}
+	cmd.AddCommand(NewCachePruneCmd())
+	return cmd
+}
+
+func NewCachePruneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old responses from the cache",
+		RunE:  RunCachePrune,
+	}
+
+	cmd.Flags().Duration(FlagOlderThan, defaultPruneAge, "remove the responses cached longer ago than this")
+	cmd.Flags().Bool(FlagAll, false, "remove every cached response")
+
+	return cmd
+}
+
+type CachePruneOptions struct {
+	OlderThan time.Duration
+	All       bool
+}
+
+func parseCachePruneOptions(cmd *cobra.Command) (opts CachePruneOptions, err error) {
+	opts.OlderThan, err = cmd.Flags().GetDuration(FlagOlderThan)
+	if err != nil {
+		return
+	}
+	opts.All, err = cmd.Flags().GetBool(FlagAll)
+	return
+}
+
+func RunCachePrune(cmd *cobra.Command, args []string) error {
+	opts, err := parseCachePruneOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	dir, err := cache.DefaultDir()
+	if err != nil {
+		return err
+	}
+	c, err := cache.Open(dir)
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-opts.OlderThan)
+	if opts.All {
+		before = time.Now().Add(time.Hour)
+	}
+	removed, err := c.Prune(before)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d cached responses from %s\n", removed, dir)
+	return nil
+}
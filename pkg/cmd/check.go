@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/git"
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagPromptGenerate = "prompt-generate"
+)
+
+func NewCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Fail when exported functions in staged files have no test calling them",
+		RunE:  RunCheck,
+		// a failing check is reported by the list of functions, not the usage
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringP(FlagProjectDirectory, "d", "", "path to the project directory (optional)")
+	cmd.Flags().Bool(FlagPromptGenerate, false, "offer to generate the missing tests, asking on the terminal")
+	addLimitsFlags(cmd)
+	addCacheFlags(cmd)
+	addSettingsFlags(cmd)
+
+	return cmd
+}
+
+type CheckOptions struct {
+	ProjectDir string
+	// PromptGenerate asks whether the missing tests should be generated.
+	PromptGenerate bool
+	Limits         GenerationLimits
+	NoCache        bool
+	Overrides      config.Settings
+	Logger         *slog.Logger
+}
+
+func parseCheckOptions(cmd *cobra.Command) (opts CheckOptions, err error) {
+	opts.ProjectDir, err = cmd.Flags().GetString(FlagProjectDirectory)
+	if err != nil {
+		return
+	}
+	opts.PromptGenerate, err = cmd.Flags().GetBool(FlagPromptGenerate)
+	if err != nil {
+		return
+	}
+	opts.Limits, err = parseLimits(cmd)
+	if err != nil {
+		return
+	}
+	opts.NoCache, err = cmd.Flags().GetBool(FlagNoCache)
+	if err != nil {
+		return
+	}
+	opts.Overrides, err = parseSettingsOverrides(cmd)
+	if err != nil {
+		return
+	}
+	opts.Logger, err = parseLogger(cmd)
+	return
+}
+
+func RunCheck(cmd *cobra.Command, args []string) error {
+	opts, err := parseCheckOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	if opts.ProjectDir != "" {
+		err = os.Chdir(opts.ProjectDir)
+		if err != nil {
+			fmt.Printf("error changing directories: %v\n", err)
+			return err
+		}
+	}
+
+	untested, err := findUntestedStaged(opts)
+	if err != nil {
+		return err
+	}
+	if len(untested) == 0 {
+		return nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tFUNCTION")
+	for _, function := range untested {
+		fmt.Fprintf(w, "%s\t%s\n", relativePath(wd, function.Filepath), function.name())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if opts.PromptGenerate {
+		if err := promptGenerate(cmd.Context(), opts, untested); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%d exported functions have no test calling them", len(untested))
+}
+
+// findUntestedStaged returns the exported functions and methods of the staged content of non-test files
+// which are not called by any function or method in the test files of their package.
+func findUntestedStaged(opts CheckOptions) ([]target, error) {
+	files, err := git.StagedFiles(".")
+	if err != nil {
+		return nil, fmt.Errorf("error listing staged files: %w", err)
+	}
+
+	untested := []target{}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		settings, err := config.Resolve(file, opts.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("error loading configuration: %w", err)
+		}
+		if settings.Excluded(file) {
+			opts.Logger.Debug("skipping excluded file", "file", file)
+			continue
+		}
+		// only the staged content is committed, which may be some of the changes to the file
+		code, err := git.StagedContent(file)
+		if err != nil {
+			return nil, err
+		}
+		functions, err := parse.ListFunctions(code)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q: %w", file, err)
+		}
+		tests, err := parse.ListPackageTestFunctions(filepath.Dir(file))
+		if err != nil {
+			return nil, err
+		}
+		names, err := packageFunctionNames(file, functions)
+		if err != nil {
+			return nil, err
+		}
+		for _, function := range functions {
+			// methods of unexported types are not part of the package's API, as with --exported-only
+			if !parse.IsExported(function.Name) || (function.Receiver != "" && !parse.IsExported(function.Receiver)) {
+				continue
+			}
+			if parse.IsReferenced(function.Receiver, function.Name, names[function.Name] == 1, tests) {
+				continue
+			}
+			untested = append(untested, target{Filepath: file, Receiver: function.Receiver, FunctionName: function.Name})
+		}
+	}
+	return untested, nil
+}
+
+// packageFunctionNames counts the functions and methods of the package of the given file by name,
+// taking the functions of the file from its staged content and those of the other files from the working tree.
+func packageFunctionNames(file string, staged []types.FunctionInfo) (map[string]int, error) {
+	names := map[string]int{}
+	for _, function := range staged {
+		names[function.Name]++
+	}
+	files, err := parse.PackageFiles(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range files[1:] {
+		code, err := os.ReadFile(other)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %q: %w", other, err)
+		}
+		functions, err := parse.ListFunctions(code)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q: %w", other, err)
+		}
+		for _, function := range functions {
+			names[function.Name]++
+		}
+	}
+	return names, nil
+}
+
+// promptGenerate asks on the terminal whether tests should be generated for the untested functions, and generates them.
+// The terminal is opened directly, since git hooks do not receive the terminal as stdin.
+func promptGenerate(ctx context.Context, opts CheckOptions, untested []target) error {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		opts.Logger.Debug("no terminal to ask whether to generate tests", "error", err)
+		return nil
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(os.Stderr, "Generate tests for %d functions? [y/N] ", len(untested))
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading answer: %w", err)
+	}
+	if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+		return nil
+	}
+
+	_, errs := generateTests(ctx, GenerateTestsOptions{
+		StyleExamples: defaultStyleExamples,
+		OutputFormat:  OutputFormatText,
+		Limits:        opts.Limits,
+		NoCache:       opts.NoCache,
+		Overrides:     opts.Overrides,
+		Logger:        opts.Logger,
+	}, untested)
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("error generating test for %q: %w", untested[i].name(), err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Review and stage the generated tests, then commit again.")
+	return nil
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/coverage"
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagPackage   = "package"
+	FlagThreshold = "threshold"
+	FlagTop       = "top"
+	FlagGenerate  = "generate"
+)
+
+func NewCoverageGapsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "coverage-gaps",
+		Short: "List the functions of a package whose test coverage is below a threshold",
+		RunE:  RunCoverageGaps,
+	}
+
+	cmd.Flags().StringP(FlagProjectDirectory, "d", "", "path to the project directory (optional)")
+	cmd.Flags().StringP(FlagPackage, "p", ".", "path to the package directory, relative to the project directory")
+	cmd.Flags().Float64P(FlagThreshold, "t", 80, "coverage percentage below which a function is reported")
+	cmd.Flags().Int(FlagTop, 0, "only report the top N functions (0 reports all of them)")
+	cmd.Flags().Bool(FlagGenerate, false, "generate tests for the reported functions")
+	addLimitsFlags(cmd)
+	addCacheFlags(cmd)
+	addSettingsFlags(cmd)
+
+	return cmd
+}
+
+type CoverageGapsOptions struct {
+	ProjectDir string
+	Package    string
+	Threshold  float64
+	Top        int
+	Generate   bool
+	Limits     GenerationLimits
+	NoCache    bool
+	Overrides  config.Settings
+	Logger     *slog.Logger
+}
+
+func parseCoverageGapsOptions(cmd *cobra.Command) (opts CoverageGapsOptions, err error) {
+	opts.ProjectDir, err = cmd.Flags().GetString(FlagProjectDirectory)
+	if err != nil {
+		return
+	}
+	opts.Package, err = cmd.Flags().GetString(FlagPackage)
+	if err != nil {
+		return
+	}
+	opts.Threshold, err = cmd.Flags().GetFloat64(FlagThreshold)
+	if err != nil {
+		return
+	}
+	opts.Top, err = cmd.Flags().GetInt(FlagTop)
+	if err != nil {
+		return
+	}
+	opts.Generate, err = cmd.Flags().GetBool(FlagGenerate)
+	if err != nil {
+		return
+	}
+	opts.Limits, err = parseLimits(cmd)
+	if err != nil {
+		return
+	}
+	opts.NoCache, err = cmd.Flags().GetBool(FlagNoCache)
+	if err != nil {
+		return
+	}
+	opts.Overrides, err = parseSettingsOverrides(cmd)
+	if err != nil {
+		return
+	}
+	opts.Logger, err = parseLogger(cmd)
+	return
+}
+
+func RunCoverageGaps(cmd *cobra.Command, args []string) error {
+	opts, err := parseCoverageGapsOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	if opts.ProjectDir != "" {
+		err = os.Chdir(opts.ProjectDir)
+		if err != nil {
+			fmt.Printf("error changing directories: %v\n", err)
+			return err
+		}
+	}
+
+	opts.Logger.Info("running tests with coverage", "package", opts.Package)
+	blocks, err := coverage.Run(opts.Logger, opts.Package)
+	if err != nil {
+		return fmt.Errorf("error collecting coverage: %w", err)
+	}
+	functions, err := coverage.Functions(opts.Package, blocks)
+	if err != nil {
+		return fmt.Errorf("error computing function coverage: %w", err)
+	}
+	gaps := []coverage.FunctionCoverage{}
+	for _, gap := range coverage.Gaps(functions, opts.Threshold) {
+		settings, err := config.Resolve(gap.Filepath, opts.Overrides)
+		if err != nil {
+			return fmt.Errorf("error loading configuration: %w", err)
+		}
+		if !settings.Excluded(gap.Filepath) {
+			gaps = append(gaps, gap)
+		}
+	}
+	if opts.Top > 0 && len(gaps) > opts.Top {
+		gaps = gaps[:opts.Top]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tFUNCTION\tCOVERAGE\tUNCOVERED\tCOMPLEXITY")
+	for _, gap := range gaps {
+		percent := fmt.Sprintf("%.1f%%", gap.Percent())
+		if !gap.Profiled {
+			percent = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", filepath.Base(gap.Filepath), qualifiedName(gap), percent, gap.Uncovered(), gap.Complexity)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !opts.Generate {
+		return nil
+	}
+	targets := []target{}
+	for _, gap := range gaps {
+		targets = append(targets, target{Filepath: gap.Filepath, Receiver: gap.Receiver, FunctionName: gap.Name})
+	}
+	_, errs := generateTests(cmd.Context(), GenerateTestsOptions{
+		StyleExamples: defaultStyleExamples,
+		OutputFormat:  OutputFormatText,
+		Limits:        opts.Limits,
+		NoCache:       opts.NoCache,
+		Overrides:     opts.Overrides,
+		Logger:        opts.Logger,
+	}, targets)
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("error generating test for %q: %w", qualifiedName(gaps[i]), err)
+		}
+	}
+	return nil
+}
+
+// qualifiedName returns the name of the function, prefixed with its receiver type for methods.
+func qualifiedName(function coverage.FunctionCoverage) string {
+	return parse.QualifiedName(function.Receiver, function.Name)
+}
@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/robotsail/go-create-test/pkg/cache"
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/lib"
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// target is a function to generate a test for.
+type target struct {
+	Filepath string
+	// Receiver is the receiver type of a method, which tells apart methods of the same name.
+	Receiver     string
+	FunctionName string
+}
+
+// name returns the name of the target, qualified by its receiver type if it is a method, e.g. 'Server.Start'.
+func (t target) name() string {
+	return parse.QualifiedName(t.Receiver, t.FunctionName)
+}
+
+// pendingTest is the generated test file for a target, which has not been merged into its destination yet.
+type pendingTest struct {
+	result       types.GenerationResult
+	conversation *lib.Conversation
+	testFilePath string
+	generated    []byte
+	err          error
+}
+
+// generateTest generates a test for the given function and writes it into the
+// test file next to the source file, merging it with any tests already there.
+// The returned result describes as much of the generation as completed, even when an error is returned.
+func generateTest(ctx context.Context, opts GenerateTestsOptions) (types.GenerationResult, error) {
+	receiver, name := parse.SplitQualifiedName(opts.FunctionName)
+	results, errs := generateTests(ctx, opts, []target{{Filepath: opts.Filepath, Receiver: receiver, FunctionName: name}})
+	return results[0], errs[0]
+}
+
+// generateTests generates tests for all of the targets, requesting up to opts.Limits.Concurrency of them at once.
+// The generated tests are then merged in the order of the targets, so that the tests of functions
+// in the same file are merged deterministically, and every test file is written once.
+// Once the context is cancelled, or a request is refused for exceeding opts.Limits.MaxCost, the remaining
+// targets fail and only the tests generated so far are written.
+// The results and errors are in the order of the targets.
+func generateTests(ctx context.Context, opts GenerateTestsOptions, targets []target) ([]types.GenerationResult, []error) {
+	run := &generationRun{
+		limiter:      lib.NewRateLimiter(opts.Limits.RequestsPerMinute, opts.Limits.TokensPerMinute),
+		responses:    openCache(opts),
+		testContexts: map[string]*packageTestContext{},
+		templates:    map[string]lib.PromptTemplates{},
+	}
+	if opts.Limits.MaxCost > 0 {
+		run.budget = lib.NewBudget(opts.Limits.MaxCost)
+	}
+	run.templatesErr = run.loadTemplates(opts.Overrides, targets)
+	progress := startProgress(opts.Logger, targets)
+
+	concurrency := opts.Limits.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pending := make([]*pendingTest, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				pending[job] = prepareTest(ctx, opts, targets[job], run, progress.target(job))
+			}
+		}()
+	}
+	for job := range targets {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+	// the display must be gone before anything else is printed, or the user is asked to review
+	progress.Stop()
+
+	writePendingTests(ctx, opts, pending)
+	results := make([]types.GenerationResult, len(pending))
+	errs := make([]error, len(pending))
+	for i, p := range pending {
+		results[i] = p.result
+		errs[i] = p.err
+	}
+	printUsageSummary(opts.Logger, results)
+	return results, errs
+}
+
+// generationRun holds the state shared by the generation of every target of a run.
+type generationRun struct {
+	limiter   *lib.RateLimiter
+	responses *cache.Cache
+	// budget is nil unless the cost of the run is limited.
+	budget *lib.Budget
+	// testContexts holds the test context of every package, keyed by its directory, since finding it
+	// walks the whole module. It is nil when the context is found again for every target, e.g. because
+	// the tests may change between targets.
+	testContexts map[string]*packageTestContext
+	// templates holds the prompt templates loaded for the settings of the targets, keyed by templateKey.
+	// Like testContexts, it is nil when the templates are loaded again for every target.
+	templates map[string]lib.PromptTemplates
+	// templatesErr is the error of loading any of the templates of the targets, which fails every target.
+	templatesErr error
+	mu           sync.Mutex
+}
+
+// templateKey identifies the prompt templates of the given settings.
+func templateKey(settings config.Settings) string {
+	return strings.Join([]string{settings.PromptTemplate, settings.SystemPromptTemplate, settings.SystemPrompt}, "\x00")
+}
+
+// loadTemplates loads and validates the prompt templates of every target before any of them is generated,
+// so that an invalid template fails the run before any requests are made. Targets whose settings cannot be
+// resolved are left to fail on their own.
+func (r *generationRun) loadTemplates(overrides config.Settings, targets []target) error {
+	for _, t := range targets {
+		settings, err := config.Resolve(t.Filepath, overrides)
+		if err != nil {
+			continue
+		}
+		if _, err := r.promptTemplates(settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promptTemplates returns the prompt templates of the given settings, loading them only once per run.
+func (r *generationRun) promptTemplates(settings config.Settings) (lib.PromptTemplates, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := templateKey(settings)
+	if templates, ok := r.templates[key]; ok {
+		return templates, nil
+	}
+	templates, err := lib.LoadPromptTemplates(settings.PromptTemplate, settings.SystemPromptTemplate, settings.SystemPrompt)
+	if err != nil {
+		return lib.PromptTemplates{}, fmt.Errorf("error loading prompt templates: %w", err)
+	}
+	if r.templates != nil {
+		r.templates[key] = templates
+	}
+	return templates, nil
+}
+
+// packageTestContext is the test context of a package, which is found once.
+type packageTestContext struct {
+	once    sync.Once
+	context parse.TestContext
+	err     error
+}
+
+// testContext returns the test context of the package in the given directory, finding it
+// only once for all of the targets in the package.
+func (r *generationRun) testContext(packageDir string) (parse.TestContext, error) {
+	if r.testContexts == nil {
+		return parse.FindTestContext(packageDir)
+	}
+	r.mu.Lock()
+	cached, ok := r.testContexts[packageDir]
+	if !ok {
+		cached = &packageTestContext{}
+		r.testContexts[packageDir] = cached
+	}
+	r.mu.Unlock()
+	cached.once.Do(func() {
+		cached.context, cached.err = parse.FindTestContext(packageDir)
+	})
+	return cached.context, cached.err
+}
+
+// prepareTest collects the context of the target and generates its test file.
+func prepareTest(ctx context.Context, opts GenerateTestsOptions, t target, run *generationRun, progress targetProgress) *pendingTest {
+	opts.Logger.Debug("generating test", "file", t.Filepath, "function", t.name())
+	p := &pendingTest{
+		result: types.GenerationResult{
+			TargetFunction: t.name(),
+			Filepath:       t.Filepath,
+			Definitions:    []types.Definition{},
+			Verification: types.Verification{
+				Compile: types.StatusSkipped,
+				Test:    types.StatusSkipped,
+			},
+			Requests: []types.RequestUsage{},
+			Errors:   []string{},
+		},
+	}
+	p.err = ctx.Err()
+	if p.err == nil {
+		p.err = run.templatesErr
+	}
+	if p.err == nil && run.budget.Exhausted() {
+		// the budget ran out for another target, so the rest of the batch is abandoned
+		p.err = lib.ErrBudgetExceeded
+	}
+	if p.err == nil {
+		p.err = p.generate(ctx, opts, t, run, progress)
+	}
+	progress.finish(p.err)
+	return p
+}
+
+func (p *pendingTest) generate(ctx context.Context, opts GenerateTestsOptions, t target, run *generationRun, progress targetProgress) error {
+	filepath, functionName := t.Filepath, t.name()
+	result := &p.result
+
+	progress.update(stageContext, "reading source")
+	settings, err := config.Resolve(filepath, opts.Overrides)
+	if err != nil {
+		return fmt.Errorf("error loading configuration: %w", err)
+	}
+	if settings.Excluded(filepath) {
+		return fmt.Errorf("%q is excluded by the configuration", filepath)
+	}
+	templates, err := run.promptTemplates(settings)
+	if err != nil {
+		return err
+	}
+
+	code, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+
+	packageName, err := parse.GetPackageName(code)
+	if err != nil {
+		return err
+	}
+	opts.Logger.Debug("found package", "package", packageName)
+
+	funcDef, err := parse.GetFunctionDefinition(functionName, code)
+	if err != nil {
+		return err
+	}
+
+	definitions, err := parse.GetFunctionCalls(opts.Logger, filepath, functionName, code, settings.FullSource, func(resolved int, total int) {
+		progress.update(stageContext, fmt.Sprintf("resolving definitions %d/%d", resolved, total))
+	})
+	if err != nil {
+		return err
+	}
+	callDefs := []string{}
+	for _, def := range definitions {
+		callDefs = append(callDefs, def.Source)
+	}
+	generics, err := parse.GetGenerics(opts.Logger, filepath, functionName, code)
+	if err != nil {
+		return fmt.Errorf("error finding type parameters: %w", err)
+	}
+	constraints := []string{}
+	for _, def := range generics.Constraints {
+		constraints = append(constraints, def.Source)
+	}
+	result.Definitions = append(definitions, generics.Constraints...)
+
+	testFileName := lib.GetTestFileName(filepath)
+	p.testFilePath = path.Join(path.Dir(filepath), testFileName)
+	if opts.Output != "" && opts.Output != stdoutOutput {
+		p.testFilePath = opts.Output
+	}
+	result.OutputPath = p.testFilePath
+	if opts.Output == stdoutOutput {
+		result.OutputPath = stdoutOutput
+	}
+	existing, err := ioutil.ReadFile(p.testFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading existing test file: %w", err)
+	}
+	styleExamples, err := findStyleExamples(opts.Logger, filepath, functionName, code, p.testFilePath, opts.StyleExamples)
+	if err != nil {
+		return fmt.Errorf("error finding existing tests: %w", err)
+	}
+
+	testContext, err := run.testContext(path.Dir(filepath))
+	if err != nil {
+		return fmt.Errorf("error finding existing test helpers: %w", err)
+	}
+
+	progress.update(stageGenerating, "generating test code")
+	conversation, err := lib.NewConversation(types.GenerationOptions{
+		Provider:       settings.Provider,
+		Model:          settings.Model,
+		BaseURL:        settings.BaseURL,
+		MaxRetries:     opts.Limits.MaxRetries,
+		RequestTimeout: opts.Limits.RequestTimeout,
+		Logger:         opts.Logger,
+		Prices:         settings.Prices,
+	}, templates, types.TestCodePrompt{
+		TargetFunction:   funcDef,
+		TypeParameters:   generics.TypeParameters,
+		Constraints:      constraints,
+		CalledFunctions:  callDefs,
+		PackageName:      packageName,
+		ExistingTests:    string(existing),
+		StyleExamples:    styleExamples,
+		TestHelpers:      testContext.Helpers,
+		Fixtures:         testContext.Fixtures,
+		TestMain:         testContext.TestMain,
+		Style:            settings.Style,
+		AssertionLibrary: settings.AssertionLibrary,
+		Instructions:     settings.Instructions,
+	})
+	if err != nil {
+		return fmt.Errorf("error generating test code: %w", err)
+	}
+	conversation.Limiter = run.limiter
+	conversation.Cache = run.responses
+	conversation.Budget = run.budget
+	conversation.Stream = progress.stream()
+	p.conversation = conversation
+	result.Model = conversation.Model()
+	testFile, err := conversation.Send(ctx)
+	p.recordUsage()
+	if err != nil {
+		return fmt.Errorf("error generating test code: %w", err)
+	}
+	if conversation.CacheHits > 0 {
+		opts.Logger.Info("using cached response", "file", filepath, "function", functionName)
+	}
+	p.generated = []byte(lib.UnwrapResponse(testFile))
+	if generics.IsGeneric() {
+		return p.checkInstantiations(ctx, opts, existing, progress)
+	}
+	return nil
+}
+
+// maxInstantiationFixes is the number of times the model is asked to fix instantiations which do not compile.
+const maxInstantiationFixes = 2
+
+// checkInstantiations compiles the generated tests of a generic function, since the model easily picks
+// type arguments which do not satisfy the constraints. Compiler errors are sent back to the model to fix,
+// and a test file which still does not compile is kept for the user to fix, with a warning.
+func (p *pendingTest) checkInstantiations(ctx context.Context, opts GenerateTestsOptions, existing []byte, progress targetProgress) error {
+	for attempt := 0; ; attempt++ {
+		progress.update(stageGenerating, "compiling instantiations")
+		merged, err := lib.MergeTestFile(existing, p.generated)
+		if err != nil {
+			return fmt.Errorf("error merging test file: %w", err)
+		}
+		output, err := lib.CompileTestFile(path.Dir(p.testFilePath), p.testFilePath, merged)
+		if err == nil {
+			return nil
+		}
+		if output == "" {
+			// the compiler could not be run at all, which the model cannot fix
+			opts.Logger.Warn("could not compile the instantiations of the generic function", "function", p.result.TargetFunction, "error", err)
+			return nil
+		}
+		if attempt == maxInstantiationFixes {
+			opts.Logger.Warn("the generated instantiations do not compile", "function", p.result.TargetFunction, "output", output)
+			return nil
+		}
+
+		opts.Logger.Debug("fixing instantiations which do not compile", "function", p.result.TargetFunction, "attempt", attempt+1)
+		progress.update(stageGenerating, "fixing instantiations")
+		response, err := p.conversation.Reply(ctx, fmt.Sprintf(
+			"The test file does not compile:\n\n%s\nFix the instantiations of %s with type arguments which satisfy its constraints, and respond with the entire test file again.",
+			output, p.result.TargetFunction,
+		))
+		p.recordUsage()
+		if err != nil {
+			return fmt.Errorf("error fixing instantiations: %w", err)
+		}
+		p.generated = []byte(lib.UnwrapResponse(response))
+	}
+}
+
+// recordUsage copies the token usage and cost of the conversation so far into the result.
+func (p *pendingTest) recordUsage() {
+	p.result.PromptTokens = p.conversation.Usage.PromptTokens
+	p.result.CompletionTokens = p.conversation.Usage.CompletionTokens
+	p.result.CacheHits = p.conversation.CacheHits
+	p.result.Requests = append([]types.RequestUsage{}, p.conversation.Requests...)
+	p.result.Cost = p.conversation.Cost()
+}
+
+// writePendingTests merges the generated tests into their test files in order,
+// and writes every test file once all of its tests are merged.
+func writePendingTests(ctx context.Context, opts GenerateTestsOptions, pending []*pendingTest) {
+	files := []string{}
+	byFile := map[string][]*pendingTest{}
+	for _, p := range pending {
+		if p.err != nil {
+			continue
+		}
+		if _, ok := byFile[p.testFilePath]; !ok {
+			files = append(files, p.testFilePath)
+		}
+		byFile[p.testFilePath] = append(byFile[p.testFilePath], p)
+	}
+	for _, testFilePath := range files {
+		writePendingFile(ctx, opts, testFilePath, byFile[testFilePath])
+	}
+}
+
+// writePendingFile merges the generated tests into a single test file and writes it.
+// Errors are recorded on the pending tests they belong to.
+func writePendingFile(ctx context.Context, opts GenerateTestsOptions, testFilePath string, pending []*pendingTest) {
+	fail := func(tests []*pendingTest, err error) {
+		for _, p := range tests {
+			p.err = err
+		}
+	}
+
+	existing, err := ioutil.ReadFile(testFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		fail(pending, fmt.Errorf("error reading existing test file: %w", err))
+		return
+	}
+	merged := existing
+	included := []*pendingTest{}
+	testNames := []string{}
+	for _, p := range pending {
+		generated := p.generated
+		if opts.Interactive {
+			generated, err = reviewTestFile(ctx, opts.Logger, p.conversation, string(generated))
+			// account for any regenerated tests
+			p.recordUsage()
+			if err != nil {
+				p.err = fmt.Errorf("error reviewing test code: %w", err)
+				continue
+			}
+		}
+		names, err := lib.TestNames(generated)
+		if err != nil {
+			p.err = fmt.Errorf("error finding generated tests: %w", err)
+			continue
+		}
+		// tests which are declared already are dropped by the merge, which must not pass for success
+		names, dropped, err := newTestNames(merged, names)
+		if err != nil {
+			p.err = fmt.Errorf("error finding existing tests: %w", err)
+			continue
+		}
+		if len(names) == 0 && len(dropped) > 0 {
+			p.err = fmt.Errorf("the generated tests are already declared in %s: %s", testFilePath, strings.Join(dropped, ", "))
+			continue
+		}
+		if len(dropped) > 0 {
+			opts.Logger.Warn("dropping generated tests which are already declared", "function", p.result.TargetFunction, "tests", strings.Join(dropped, ", "))
+		}
+		next, err := lib.MergeTestFile(merged, generated)
+		if err != nil {
+			p.err = fmt.Errorf("error merging test file: %w", err)
+			continue
+		}
+		merged = next
+		included = append(included, p)
+		testNames = append(testNames, names...)
+	}
+	if len(included) == 0 {
+		return
+	}
+
+	written, output, err := writeTestFile(opts, testFilePath, existing, merged)
+	if err != nil {
+		fail(included, err)
+		return
+	}
+	if output != "" {
+		if opts.OutputFormat == OutputFormatJSON {
+			for _, p := range included {
+				p.result.Output = output
+			}
+		} else {
+			fmt.Print(output)
+		}
+	}
+	if !written || !opts.Verify {
+		return
+	}
+
+	verification := lib.VerifyTests(path.Dir(testFilePath), testNames)
+	for _, p := range included {
+		p.result.Verification = verification
+	}
+	if opts.OutputFormat == OutputFormatText {
+		fmt.Printf("Compile: %s, tests: %s\n", verification.Compile, verification.Test)
+		if verification.Compile == types.StatusFailed || verification.Test == types.StatusFailed {
+			fmt.Print(verification.Output)
+		}
+	}
+}
+
+// newTestNames splits the names of generated tests into those which are not declared in the test file yet,
+// and those which are and would be dropped when merging.
+func newTestNames(testFile []byte, names []string) (added []string, dropped []string, err error) {
+	declared := map[string]bool{}
+	if len(bytes.TrimSpace(testFile)) > 0 {
+		existing, err := lib.TestNames(testFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range existing {
+			declared[name] = true
+		}
+	}
+	for _, name := range names {
+		if declared[name] {
+			dropped = append(dropped, name)
+		} else {
+			added = append(added, name)
+		}
+	}
+	return added, dropped, nil
+}
+
+// writeTestFile writes the merged test file to its destination, or returns it for a dry run.
+// Reports whether the test file was written to disk, and the output to show otherwise.
+func writeTestFile(opts GenerateTestsOptions, testFilePath string, existing []byte, merged []byte) (bool, string, error) {
+	switch {
+	case opts.DryRun && len(existing) > 0:
+		return false, lib.UnifiedDiff("a/"+testFilePath, "b/"+testFilePath, string(existing), string(merged)), nil
+	case opts.DryRun || opts.Output == stdoutOutput:
+		return false, string(merged), nil
+	}
+	err := ioutil.WriteFile(testFilePath, merged, 0644)
+	if err != nil {
+		return false, "", fmt.Errorf("error writing test file: %w", err)
+	}
+	if opts.OutputFormat == OutputFormatText {
+		fmt.Printf("Done! Test file written to %s\n", testFilePath)
+	}
+	return true, "", nil
+}
+
+// findStyleExamples selects existing tests from the package of the given file to serve as examples.
+func findStyleExamples(logger *slog.Logger, filepath string, functionName string, code []byte, testFilePath string, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	functions, err := parse.ListPackageTestFunctions(path.Dir(filepath))
+	if err != nil {
+		return nil, err
+	}
+	calls, err := parse.GetCalledNames(logger, functionName, code)
+	if err != nil {
+		logger.Warn("could not find the calls of the function, examples will not be ranked by them", "function", functionName, "error", err)
+	}
+	return parse.SelectStyleExamples(functions, calls, testFilePath, limit), nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/git"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagForce = "force"
+)
+
+// hookMarker identifies pre-commit hooks written by install-hook, which may be overwritten safely.
+const hookMarker = "# installed by go-create-test install-hook"
+
+func NewInstallHookCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install-hook",
+		Short: "Install a git pre-commit hook which runs the check command",
+		RunE:  RunInstallHook,
+	}
+
+	cmd.Flags().StringP(FlagProjectDirectory, "d", "", "path to the project directory (optional)")
+	cmd.Flags().Bool(FlagPromptGenerate, false, "offer to generate the missing tests when the check fails")
+	cmd.Flags().Bool(FlagForce, false, "overwrite an existing pre-commit hook")
+
+	return cmd
+}
+
+type InstallHookOptions struct {
+	ProjectDir     string
+	PromptGenerate bool
+	Force          bool
+}
+
+func parseInstallHookOptions(cmd *cobra.Command) (opts InstallHookOptions, err error) {
+	opts.ProjectDir, err = cmd.Flags().GetString(FlagProjectDirectory)
+	if err != nil {
+		return
+	}
+	opts.PromptGenerate, err = cmd.Flags().GetBool(FlagPromptGenerate)
+	if err != nil {
+		return
+	}
+	opts.Force, err = cmd.Flags().GetBool(FlagForce)
+	return
+}
+
+func RunInstallHook(cmd *cobra.Command, args []string) error {
+	opts, err := parseInstallHookOptions(cmd)
+	if err != nil {
+		return err
+	}
+	dir := opts.ProjectDir
+	if dir == "" {
+		dir = "."
+	}
+
+	hooksDir, err := git.HooksDir(dir)
+	if err != nil {
+		return fmt.Errorf("error finding git hooks directory: %w", err)
+	}
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+	existing, err := ioutil.ReadFile(hookPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading existing hook: %w", err)
+	}
+	if err == nil && !opts.Force && !strings.Contains(string(existing), hookMarker) {
+		return fmt.Errorf("%s already exists, use --%s to overwrite it", hookPath, FlagForce)
+	}
+
+	executable, err := hookExecutable()
+	if err != nil {
+		return err
+	}
+	command := shellQuote(executable) + " check"
+	if opts.PromptGenerate {
+		command += " --" + FlagPromptGenerate
+	}
+	hook := "#!/bin/sh\n" + hookMarker + "\nexec " + command + "\n"
+
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("error creating hooks directory: %w", err)
+	}
+	if err := ioutil.WriteFile(hookPath, []byte(hook), 0755); err != nil {
+		return fmt.Errorf("error writing hook: %w", err)
+	}
+	fmt.Printf("Done! Pre-commit hook written to %s\n", hookPath)
+	return nil
+}
+
+// hookExecutable returns the command the hook runs: go-create-test from the PATH if it is installed,
+// otherwise the path of the running executable.
+func hookExecutable() (string, error) {
+	if path, err := exec.LookPath("go-create-test"); err == nil {
+		return path, nil
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("could not find the go-create-test executable: %w", err)
+	}
+	return path, nil
+}
+
+// shellQuote quotes the string for use as a single word in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagConcurrency       = "concurrency"
+	FlagRequestsPerMinute = "requests-per-minute"
+	FlagTokensPerMinute   = "tokens-per-minute"
+	FlagMaxRetries        = "max-retries"
+	FlagRequestTimeout    = "request-timeout"
+	FlagMaxCost           = "max-cost"
+)
+
+// Defaults of the generation limits.
+const (
+	defaultConcurrency    = 4
+	defaultMaxRetries     = 5
+	defaultRequestTimeout = 2 * time.Minute
+)
+
+// GenerationLimits limit how many tests are generated at once, and how fast requests are sent to the model.
+type GenerationLimits struct {
+	Concurrency int
+	// RequestsPerMinute and TokensPerMinute are the rate limits of the model, or zero for no limit.
+	RequestsPerMinute int
+	TokensPerMinute   int
+	// MaxRetries is the number of times a request failing with a rate limit, server error, or timeout is retried.
+	MaxRetries int
+	// RequestTimeout limits the wait for the response to each attempt of a request to the model,
+	// but not the reading of a streamed response once it has started.
+	RequestTimeout time.Duration
+	// MaxCost is the estimated cost in US dollars the requests may not exceed, or zero for no limit.
+	MaxCost float64
+}
+
+// addLimitsFlags registers the flags for commands which may generate several tests.
+func addLimitsFlags(cmd *cobra.Command) {
+	cmd.Flags().Int(FlagConcurrency, defaultConcurrency, "number of tests to generate at once")
+	cmd.Flags().Int(FlagRequestsPerMinute, 0, "maximum number of requests to the model per minute (0 for no limit)")
+	cmd.Flags().Int(FlagTokensPerMinute, 0, "maximum number of tokens sent to and generated by the model per minute (0 for no limit)")
+	cmd.Flags().Int(FlagMaxRetries, defaultMaxRetries, "number of times a request failing with a rate limit, server error, or timeout is retried")
+	cmd.Flags().Duration(FlagRequestTimeout, defaultRequestTimeout, "timeout of each request to the model until its response starts (0 for no timeout)")
+	cmd.Flags().Float64(FlagMaxCost, 0, "estimated cost in US dollars after which no further requests are sent (0 for no limit)")
+}
+
+func parseLimits(cmd *cobra.Command) (limits GenerationLimits, err error) {
+	limits.Concurrency, err = cmd.Flags().GetInt(FlagConcurrency)
+	if err != nil {
+		return
+	}
+	limits.RequestsPerMinute, err = cmd.Flags().GetInt(FlagRequestsPerMinute)
+	if err != nil {
+		return
+	}
+	limits.TokensPerMinute, err = cmd.Flags().GetInt(FlagTokensPerMinute)
+	if err != nil {
+		return
+	}
+	limits.MaxRetries, err = cmd.Flags().GetInt(FlagMaxRetries)
+	if err != nil {
+		return
+	}
+	limits.RequestTimeout, err = cmd.Flags().GetDuration(FlagRequestTimeout)
+	if err != nil {
+		return
+	}
+	limits.MaxCost, err = cmd.Flags().GetFloat64(FlagMaxCost)
+	if err != nil {
+		return
+	}
+	if limits.MaxCost < 0 {
+		err = fmt.Errorf("--%s cannot be negative", FlagMaxCost)
+	}
+	return
+}
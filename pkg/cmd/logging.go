@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/robotsail/go-create-test/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagVerbose   = "verbose"
+	FlagQuiet     = "quiet"
+	FlagLogFormat = "log-format"
+)
+
+// addLoggingFlags registers the flags controlling the logs written to stderr on every subcommand.
+func addLoggingFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolP(FlagVerbose, "v", false, "log debugging details")
+	cmd.PersistentFlags().BoolP(FlagQuiet, "q", false, "only log errors, and hide spinners and progress bars")
+	cmd.PersistentFlags().String(FlagLogFormat, logging.FormatText, "format of the logs, either \"text\" or \"json\"")
+}
+
+// parseLogger creates the logger configured by the logging flags.
+func parseLogger(cmd *cobra.Command) (*slog.Logger, error) {
+	verbose, err := cmd.Flags().GetBool(FlagVerbose)
+	if err != nil {
+		return nil, err
+	}
+	quiet, err := cmd.Flags().GetBool(FlagQuiet)
+	if err != nil {
+		return nil, err
+	}
+	format, err := cmd.Flags().GetString(FlagLogFormat)
+	if err != nil {
+		return nil, err
+	}
+	if verbose && quiet {
+		return nil, fmt.Errorf("--%s and --%s cannot be used together", FlagVerbose, FlagQuiet)
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelError
+	}
+	return logging.New(os.Stderr, format, level)
+}
+
+// startSpinner starts a spinner with the given prefix on w,
+// unless stdout is not a terminal or the logger is quiet.
+// Stopping the returned spinner is safe either way.
+func startSpinner(logger *slog.Logger, w io.Writer, prefix string) *spinner.Spinner {
+	s := spinner.New(spinner.CharSets[20], 100*time.Millisecond, spinner.WithWriter(w))
+	s.Prefix = prefix
+	if logging.ShowProgress(logger) {
+		s.Start()
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/lib"
+	"github.com/robotsail/go-create-test/pkg/lsp"
+	"github.com/spf13/cobra"
+)
+
+func NewLSPCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lsp",
+		Short: "Serve a \"Generate test\" code action to editors over the Language Server Protocol on stdio",
+		RunE:  RunLSP,
+		// stdout carries the protocol, so nothing else may be printed to it
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	cmd.Flags().Int(FlagStyleExamples, defaultStyleExamples, "number of existing tests in the package to include as examples (0 disables them)")
+	addLimitsFlags(cmd)
+	addCacheFlags(cmd)
+	addSettingsFlags(cmd)
+
+	return cmd
+}
+
+type LSPOptions struct {
+	// StyleExamples is the number of existing tests to include in the prompt as examples.
+	StyleExamples int
+	Limits        GenerationLimits
+	NoCache       bool
+	Overrides     config.Settings
+	Logger        *slog.Logger
+}
+
+func parseLSPOptions(cmd *cobra.Command) (opts LSPOptions, err error) {
+	opts.StyleExamples, err = cmd.Flags().GetInt(FlagStyleExamples)
+	if err != nil {
+		return
+	}
+	opts.Limits, err = parseLimits(cmd)
+	if err != nil {
+		return
+	}
+	opts.NoCache, err = cmd.Flags().GetBool(FlagNoCache)
+	if err != nil {
+		return
+	}
+	opts.Overrides, err = parseSettingsOverrides(cmd)
+	if err != nil {
+		return
+	}
+	opts.Logger, err = parseLogger(cmd)
+	return
+}
+
+func RunLSP(cmd *cobra.Command, args []string) error {
+	opts, err := parseLSPOptions(cmd)
+	if err != nil {
+		return err
+	}
+	generateOpts := GenerateTestsOptions{
+		StyleExamples: opts.StyleExamples,
+		// nothing but the protocol may be written to stdout
+		OutputFormat: OutputFormatJSON,
+		Limits:       opts.Limits,
+		NoCache:      opts.NoCache,
+		Overrides:    opts.Overrides,
+		Logger:       opts.Logger,
+	}
+	// the limits and the budget apply to the whole session, while the test context of a package
+	// is found again for every request, since its tests are edited during the session
+	run := &generationRun{
+		limiter:   lib.NewRateLimiter(opts.Limits.RequestsPerMinute, opts.Limits.TokensPerMinute),
+		responses: openCache(generateOpts),
+	}
+	if opts.Limits.MaxCost > 0 {
+		run.budget = lib.NewBudget(opts.Limits.MaxCost)
+	}
+
+	server := lsp.NewServer(os.Stdin, os.Stdout, opts.Logger, func(ctx context.Context, file string, receiver string, function string) (lsp.TestFile, error) {
+		return generateTestFile(ctx, generateOpts, target{Filepath: file, Receiver: receiver, FunctionName: function}, run)
+	})
+	opts.Logger.Debug("serving the language server protocol on stdio")
+	if err := server.Run(cmd.Context()); err != nil {
+		opts.Logger.Error("language server stopped", "error", err)
+		return err
+	}
+	return nil
+}
+
+// generateTestFile generates the test of the target and merges it into its test file, without writing it.
+func generateTestFile(ctx context.Context, opts GenerateTestsOptions, t target, run *generationRun) (lsp.TestFile, error) {
+	p := prepareTest(ctx, opts, t, run, targetProgress{})
+	if p.err != nil {
+		return lsp.TestFile{}, p.err
+	}
+	testFile := lsp.TestFile{Path: p.testFilePath}
+	existing, err := ioutil.ReadFile(p.testFilePath)
+	switch {
+	case err == nil:
+		testFile.Exists = true
+		testFile.Existing = string(existing)
+	case !os.IsNotExist(err):
+		return lsp.TestFile{}, fmt.Errorf("error reading existing test file: %w", err)
+	}
+	merged, err := lib.MergeTestFile(existing, p.generated)
+	if err != nil {
+		return lsp.TestFile{}, fmt.Errorf("error merging test file: %w", err)
+	}
+	testFile.Content = string(merged)
+	return testFile, nil
+}
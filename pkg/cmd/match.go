@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// regexpMetacharacters are the characters which tell a regular expression apart from a glob.
+const regexpMetacharacters = `^$+()|\{}`
+
+// namePattern matches the names of functions against a glob, e.g. '*Handler', or a regular expression, e.g. '^Parse'.
+type namePattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+// compileNamePattern compiles the pattern as a regular expression if it contains any character
+// which has no meaning in a glob, and as a glob otherwise.
+func compileNamePattern(pattern string) (namePattern, error) {
+	if strings.ContainsAny(pattern, regexpMetacharacters) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return namePattern{}, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+		return namePattern{regexp: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return namePattern{}, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	return namePattern{glob: pattern}, nil
+}
+
+// matches reports whether the name of the function, or its name qualified by its receiver type
+// such as 'Server.Start', matches the pattern. A glob matches whole names, while a regular expression
+// matches anywhere within them unless it is anchored.
+func (p namePattern) matches(function types.FunctionInfo) bool {
+	names := []string{function.Name}
+	if function.Receiver != "" {
+		names = append(names, parse.QualifiedName(function.Receiver, function.Name))
+	}
+	for _, name := range names {
+		if p.regexp != nil {
+			if p.regexp.MatchString(name) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p.glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// targetFilter selects which functions tests are generated for when several functions are targeted at once.
+type targetFilter struct {
+	// Match selects the functions whose names match it, or every function if it is nil.
+	Match *namePattern
+	// ExportedOnly leaves out unexported functions, and methods of unexported types.
+	ExportedOnly bool
+	// Skip leaves out the functions whose names match any of its patterns.
+	Skip []namePattern
+}
+
+// selects reports whether the filter selects the function.
+func (f targetFilter) selects(function types.FunctionInfo) bool {
+	if f.ExportedOnly && (!parse.IsExported(function.Name) || (function.Receiver != "" && !parse.IsExported(function.Receiver))) {
+		return false
+	}
+	for _, skip := range f.Skip {
+		if skip.matches(function) {
+			return false
+		}
+	}
+	return f.Match == nil || f.Match.matches(function)
+}
+
+// generateMatchedTests generates tests for every function selected by opts.Filter, within opts.Filepath
+// or, when it is not set, within the non-test files of the package in the current directory.
+func generateMatchedTests(ctx context.Context, opts GenerateTestsOptions) error {
+	targets, err := findMatchingFunctions(opts)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		opts.Logger.Info("no functions match")
+	}
+	for _, t := range targets {
+		opts.Logger.Info("generating test for matching function", "file", t.Filepath, "function", t.name())
+	}
+	return generateTargets(ctx, opts, targets)
+}
+
+// findMatchingFunctions returns the functions selected by opts.Filter, ordered by file and position.
+func findMatchingFunctions(opts GenerateTestsOptions) ([]target, error) {
+	files := []string{opts.Filepath}
+	if opts.Filepath == "" {
+		matches, err := filepath.Glob("*.go")
+		if err != nil {
+			return nil, fmt.Errorf("could not list package files: %w", err)
+		}
+		files = []string{}
+		for _, file := range matches {
+			if !strings.HasSuffix(file, "_test.go") {
+				files = append(files, file)
+			}
+		}
+	}
+
+	matching := []target{}
+	for _, file := range files {
+		settings, err := config.Resolve(file, opts.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("error loading configuration: %w", err)
+		}
+		if settings.Excluded(file) {
+			opts.Logger.Debug("skipping excluded file", "file", file)
+			continue
+		}
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		functions, err := parse.ListFunctions(code)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q: %w", file, err)
+		}
+		for _, function := range functions {
+			if opts.Filter.selects(function) {
+				matching = append(matching, target{Filepath: file, Receiver: function.Receiver, FunctionName: function.Name})
+			}
+		}
+	}
+	return matching, nil
+}
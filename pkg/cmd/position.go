@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// positionPattern matches a target function given by a position within its file, e.g. 'file.go:42' or 'file.go:42:7'.
+var positionPattern = regexp.MustCompile(`^(.+\.go):(\d+)(?::(\d+))?$`)
+
+// maxNearbyFunctions limits how many functions are suggested when a position is outside of any function.
+const maxNearbyFunctions = 5
+
+// parseTargetPosition splits a target given as 'file.go:line[:column]' into its file and position.
+// It reports false when the target is a function name instead.
+func parseTargetPosition(target string) (string, types.Position, bool) {
+	matches := positionPattern.FindStringSubmatch(target)
+	if matches == nil {
+		return "", types.Position{}, false
+	}
+	position := types.Position{}
+	position.Line, _ = strconv.Atoi(matches[2])
+	if matches[3] != "" {
+		position.Column, _ = strconv.Atoi(matches[3])
+	}
+	if position.Line == 0 {
+		return "", types.Position{}, false
+	}
+	return matches[1], position, true
+}
+
+// resolveTargetPosition returns the name of the function or method whose declaration encloses the position
+// within the file, qualified by its receiver type for a method so that methods of the same name are told apart.
+// When there is none, the error lists the functions nearest to the position.
+func resolveTargetPosition(file string, position types.Position) (string, error) {
+	code, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("could not read file: %w", err)
+	}
+	functions, err := parse.ListFunctions(code)
+	if err != nil {
+		return "", fmt.Errorf("could not list functions: %w", err)
+	}
+	if function, ok := parse.FunctionAt(functions, position); ok {
+		return parse.QualifiedName(function.Receiver, function.Name), nil
+	}
+
+	location := fmt.Sprintf("%s:%d", file, position.Line)
+	if position.Column > 0 {
+		location += fmt.Sprintf(":%d", position.Column)
+	}
+	nearby := parse.NearbyFunctions(functions, position.Line, maxNearbyFunctions)
+	if len(nearby) == 0 {
+		return "", fmt.Errorf("no function or method is declared at %s, and the file declares none", location)
+	}
+	names := []string{}
+	for _, function := range nearby {
+		name := parse.QualifiedName(function.Receiver, function.Name)
+		start, end := function.Range.Start.Row+1, function.Range.End.Row+1
+		if start == end {
+			names = append(names, fmt.Sprintf("%s (line %d)", name, start))
+		} else {
+			names = append(names, fmt.Sprintf("%s (lines %d-%d)", name, start, end))
+		}
+	}
+	return "", fmt.Errorf("no function or method is declared at %s, nearby functions: %s", location, strings.Join(names, ", "))
+}
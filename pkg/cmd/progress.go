@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/briandowns/spinner"
+	"github.com/cheggaaa/pb/v3"
+	"github.com/robotsail/go-create-test/pkg/logging"
+)
+
+// Stages of generating the test of a single target, shown as the progress of its bar.
+const (
+	stageContext = iota
+	stageGenerating
+	stageDone
+)
+
+// progressTemplate renders a bar as the target, its progress through the stages, and the current step.
+const progressTemplate = `{{string . "target"}} {{bar . "[" "=" ">" " " "]"}} {{string . "status"}}`
+
+// progressDisplay shows a bar for each target while their tests are generated.
+// A single target is shown live instead, with a spinner while its context is collected
+// followed by the test code as the model generates it.
+// When progress is hidden, it has no bars and updating them does nothing.
+type progressDisplay struct {
+	pool *pb.Pool
+	bars []*pb.ProgressBar
+	live *liveProgress
+}
+
+// startProgress shows a bar for each of the targets, unless progress is hidden for the logger.
+func startProgress(logger *slog.Logger, targets []target) *progressDisplay {
+	if !logging.ShowProgress(logger) {
+		return &progressDisplay{}
+	}
+	if len(targets) == 1 {
+		return &progressDisplay{live: startLiveProgress(logger, os.Stderr)}
+	}
+	bars := []*pb.ProgressBar{}
+	for _, t := range targets {
+		bar := pb.New(stageDone).SetTemplateString(progressTemplate).SetMaxWidth(120)
+		bar.Set("target", filepath.Base(t.Filepath)+":"+t.name())
+		bar.Set("status", "waiting")
+		bars = append(bars, bar)
+	}
+	pool := pb.NewPool(bars...)
+	// keep stdout free for the generated output
+	pool.Output = os.Stderr
+	if err := pool.Start(); err != nil {
+		logger.Debug("could not show progress", "error", err)
+		return &progressDisplay{}
+	}
+	return &progressDisplay{pool: pool, bars: bars}
+}
+
+// target returns the progress of the target with the given index.
+func (d *progressDisplay) target(i int) targetProgress {
+	if d.live != nil {
+		return targetProgress{live: d.live}
+	}
+	if d.pool == nil {
+		return targetProgress{}
+	}
+	return targetProgress{bar: d.bars[i]}
+}
+
+// Stop removes the display from the terminal.
+func (d *progressDisplay) Stop() {
+	if d.pool != nil {
+		_ = d.pool.Stop()
+	}
+	if d.live != nil {
+		d.live.stop()
+	}
+}
+
+// targetProgress is the bar or live display of a single target, or a no-op when progress is hidden.
+type targetProgress struct {
+	bar  *pb.ProgressBar
+	live *liveProgress
+}
+
+func (t targetProgress) update(stage int, status string) {
+	if t.live != nil {
+		t.live.update(stage, status)
+		return
+	}
+	if t.bar == nil {
+		return
+	}
+	t.bar.SetCurrent(int64(stage))
+	t.bar.Set("status", status)
+}
+
+// stream returns the writer the test code is shown on as it is generated, or nil if it is not shown.
+func (t targetProgress) stream() io.Writer {
+	if t.live == nil {
+		return nil
+	}
+	return t.live
+}
+
+func (t targetProgress) finish(err error) {
+	if t.live != nil {
+		t.live.stop()
+		return
+	}
+	if t.bar == nil {
+		return
+	}
+	if err != nil {
+		t.bar.Set("status", "failed")
+	} else {
+		t.bar.SetCurrent(stageDone)
+		t.bar.Set("status", "done")
+	}
+	t.bar.Finish()
+}
+
+// liveProgress shows the progress of a single target on a terminal: a spinner with the current step
+// until the model starts generating, and then the generated code itself.
+type liveProgress struct {
+	spinner *spinner.Spinner
+	out     io.Writer
+	// streamed is set once any generated code was written, so that it can be ended with a newline.
+	streamed bool
+}
+
+func startLiveProgress(logger *slog.Logger, out io.Writer) *liveProgress {
+	return &liveProgress{spinner: startSpinner(logger, out, ""), out: out}
+}
+
+func (l *liveProgress) update(stage int, status string) {
+	if stage >= stageGenerating {
+		// the generated code is shown below the step instead of a spinner
+		l.spinner.Stop()
+		if l.streamed {
+			fmt.Fprintln(l.out)
+			l.streamed = false
+		}
+		fmt.Fprintf(l.out, "%s%s...\n", strings.ToUpper(status[:1]), status[1:])
+		return
+	}
+	l.spinner.Lock()
+	l.spinner.Suffix = " " + status
+	l.spinner.Unlock()
+}
+
+// Write shows the generated code as it arrives.
+func (l *liveProgress) Write(p []byte) (int, error) {
+	l.streamed = l.streamed || len(p) > 0
+	return l.out.Write(p)
+}
+
+func (l *liveProgress) stop() {
+	l.spinner.Stop()
+	if l.streamed {
+		fmt.Fprintln(l.out)
+		l.streamed = false
+	}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/lib"
+	"github.com/robotsail/go-create-test/pkg/logging"
+)
+
+// reviewer lets the user accept, reject, regenerate, or edit each generated test function.
+type reviewer struct {
+	ctx          context.Context
+	logger       *slog.Logger
+	conversation *lib.Conversation
+	in           *bufio.Reader
+	out          io.Writer
+	// color is whether the functions are highlighted, which is only done when out is a terminal.
+	color bool
+}
+
+// reviewTestFile presents every function of the generated test file for review, and returns the test file
+// containing only the accepted functions. Other declarations, such as helper types and variables, are not
+// reviewed and are always kept, since accepted functions may depend on them.
+func reviewTestFile(ctx context.Context, logger *slog.Logger, conversation *lib.Conversation, generated string) ([]byte, error) {
+	r := &reviewer{
+		ctx:          ctx,
+		logger:       logger,
+		conversation: conversation,
+		in:           bufio.NewReader(os.Stdin),
+		out:          os.Stderr,
+		color:        logging.IsTerminalFile(os.Stderr),
+	}
+	testFile, err := lib.SplitTestFile([]byte(generated))
+	if err != nil {
+		return nil, err
+	}
+
+	reviewed := testFile
+	reviewed.Decls = []lib.TestDecl{}
+	for i := 0; i < len(testFile.Decls); i++ {
+		decl := testFile.Decls[i]
+		if !decl.IsFunc {
+			reviewed.Decls = append(reviewed.Decls, decl)
+			continue
+		}
+		accepted, err := r.review(&testFile, decl)
+		if err != nil {
+			return nil, err
+		}
+		if accepted != nil {
+			reviewed.Decls = append(reviewed.Decls, *accepted)
+		}
+	}
+	// regenerated functions may have added imports
+	reviewed.Imports = testFile.Imports
+	return reviewed.Source()
+}
+
+// review prompts the user until the function is accepted or rejected.
+// The accepted version of the function is returned, or nil if it was rejected.
+func (r *reviewer) review(testFile *lib.TestFile, decl lib.TestDecl) (*lib.TestDecl, error) {
+	for {
+		source := decl.Source
+		if r.color {
+			source = lib.Highlight(source)
+		}
+		fmt.Fprintf(r.out, "\n%s\n\n", source)
+		answer, err := r.ask(fmt.Sprintf("%s: [a]ccept, [r]eject, re[g]enerate with feedback, [e]dit? ", decl.Name))
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(answer) {
+		case "a", "accept":
+			return &decl, nil
+		case "r", "reject":
+			return nil, nil
+		case "g", "regenerate":
+			feedback, err := r.ask("What should be changed? ")
+			if err != nil {
+				return nil, err
+			}
+			regenerated, err := r.regenerate(testFile, decl, feedback)
+			if err != nil {
+				fmt.Fprintf(r.out, "error regenerating %s: %v\n", decl.Name, err)
+				continue
+			}
+			decl = regenerated
+		case "e", "edit":
+			edited, err := editDecl(decl)
+			if err != nil {
+				fmt.Fprintf(r.out, "error editing %s: %v\n", decl.Name, err)
+				continue
+			}
+			decl = edited
+		default:
+			fmt.Fprintf(r.out, "unknown answer %q\n", answer)
+		}
+	}
+}
+
+func (r *reviewer) ask(question string) (string, error) {
+	fmt.Fprint(r.out, question)
+	answer, err := r.in.ReadString('\n')
+	if err != nil && !(err == io.EOF && answer != "") {
+		return "", fmt.Errorf("error reading answer: %w", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// regenerate sends the feedback to the model and returns the new version of the function.
+// Imports of the regenerated file are added to the test file.
+func (r *reviewer) regenerate(testFile *lib.TestFile, decl lib.TestDecl, feedback string) (lib.TestDecl, error) {
+	// show the regenerated test file as it arrives when the terminal can display it
+	r.conversation.Stream = nil
+	if logging.ShowProgress(r.logger) {
+		fmt.Fprintln(r.out, "Regenerating test code...")
+		r.conversation.Stream = r.out
+	}
+	response, err := r.conversation.Reply(r.ctx, fmt.Sprintf(
+		"Rewrite %s with the following feedback, and respond with the entire test file again: %s", decl.Name, feedback,
+	))
+	if r.conversation.Stream != nil {
+		fmt.Fprintln(r.out)
+		r.conversation.Stream = nil
+	}
+	if err != nil {
+		return lib.TestDecl{}, err
+	}
+
+	regenerated, err := lib.SplitTestFile([]byte(lib.UnwrapResponse(response)))
+	if err != nil {
+		return lib.TestDecl{}, err
+	}
+	index := regenerated.Find(decl.Name)
+	if index == -1 {
+		return lib.TestDecl{}, fmt.Errorf("the regenerated test file does not contain %s", decl.Name)
+	}
+	testFile.Imports = append(testFile.Imports, regenerated.Imports...)
+	return regenerated.Decls[index], nil
+}
+
+// editDecl opens the declaration in the user's $EDITOR and returns the edited version.
+func editDecl(decl lib.TestDecl) (lib.TestDecl, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	file, err := ioutil.TempFile("", "go-create-test-*.go")
+	if err != nil {
+		return lib.TestDecl{}, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(decl.Source + "\n"); err != nil {
+		file.Close()
+		return lib.TestDecl{}, fmt.Errorf("could not write temporary file: %w", err)
+	}
+	file.Close()
+
+	// the editor may contain arguments, e.g. 'code --wait'
+	args := strings.Fields(editor)
+	command := exec.Command(args[0], append(args[1:], file.Name())...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return lib.TestDecl{}, fmt.Errorf("error running editor: %w", err)
+	}
+
+	edited, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return lib.TestDecl{}, fmt.Errorf("could not read edited file: %w", err)
+	}
+	decl.Source = strings.TrimSpace(string(edited))
+	return decl, nil
+}
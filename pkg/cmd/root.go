@@ -1,26 +1,20 @@
 package cmd
 
 import (
-	"log"
-
 	"github.com/spf13/cobra"
 )
 
 func NewRootCmd() *cobra.Command {
-	var filepath string
-	var functionName string
-
 	rootCmd := &cobra.Command{
-		Use:   "create-test",
-		Short: "Generate a test for a given function within the provided file",
-		Run: func(cmd *cobra.Command, args []string) {
-			Test(filepath, functionName)
-		},
+		Use:   "go-create-test",
+		Short: "Generate tests for Go functions using the OpenAI API",
 	}
+	addLoggingFlags(rootCmd)
 	rootCmd.AddCommand(NewGenerateTestCmd())
+	rootCmd.AddCommand(NewCoverageGapsCmd())
+	rootCmd.AddCommand(NewCheckCmd())
+	rootCmd.AddCommand(NewInstallHookCmd())
+	rootCmd.AddCommand(NewCacheCmd())
+	rootCmd.AddCommand(NewLSPCmd())
 	return rootCmd
 }
-
-func Test(filename string, functionName string) {
-	log.Println(`got "` + filename + `" and "` + functionName + `"`)
-}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagModel            = "model"
+	FlagProvider         = "provider"
+	FlagBaseURL          = "base-url"
+	FlagStyle            = "style"
+	FlagAssertionLibrary = "assertion-library"
+	FlagPromptTemplate   = "prompt-template"
+	FlagSystemTemplate   = "system-prompt-template"
+)
+
+// addSettingsFlags registers the flags which override values from the configuration files.
+func addSettingsFlags(cmd *cobra.Command) {
+	cmd.Flags().String(FlagModel, "", "model used to generate tests (default \"gpt-4\")")
+	cmd.Flags().String(FlagProvider, "", "provider of the model, either \"openai\" or \"azure\" (default \"openai\")")
+	cmd.Flags().String(FlagBaseURL, "", "base URL of the provider's API")
+	cmd.Flags().String(FlagStyle, "", "style the generated tests should follow, e.g. \"table-driven\"")
+	cmd.Flags().String(FlagAssertionLibrary, "", "assertion library the generated tests should use, e.g. \"testify\"")
+	cmd.Flags().String(FlagPromptTemplate, "", "path to a template file for the prompt")
+	cmd.Flags().String(FlagSystemTemplate, "", "path to a template file for the system prompt")
+}
+
+// parseSettingsOverrides returns the settings given through the environment,
+// with any values given through flags taking precedence.
+func parseSettingsOverrides(cmd *cobra.Command) (settings config.Settings, err error) {
+	flags := config.Settings{}
+	flags.Model, err = cmd.Flags().GetString(FlagModel)
+	if err != nil {
+		return
+	}
+	flags.Provider, err = cmd.Flags().GetString(FlagProvider)
+	if err != nil {
+		return
+	}
+	flags.BaseURL, err = cmd.Flags().GetString(FlagBaseURL)
+	if err != nil {
+		return
+	}
+	flags.Style, err = cmd.Flags().GetString(FlagStyle)
+	if err != nil {
+		return
+	}
+	flags.AssertionLibrary, err = cmd.Flags().GetString(FlagAssertionLibrary)
+	if err != nil {
+		return
+	}
+	flags.PromptTemplate, err = cmd.Flags().GetString(FlagPromptTemplate)
+	if err != nil {
+		return
+	}
+	flags.SystemPromptTemplate, err = cmd.Flags().GetString(FlagSystemTemplate)
+	if err != nil {
+		return
+	}
+	// template paths are given relative to where the command was run
+	flags, err = flags.AbsolutePaths()
+	if err != nil {
+		return
+	}
+	settings = config.FromEnv().Merge(flags)
+	return
+}
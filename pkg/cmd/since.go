@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/config"
+	"github.com/robotsail/go-create-test/pkg/git"
+	"github.com/robotsail/go-create-test/pkg/parse"
+)
+
+// generateChangedTests generates tests for every function which changed since opts.Since
+// and has no test named after it yet, and is selected by opts.Filter. When opts.Filepath is set,
+// only that file is considered.
+func generateChangedTests(ctx context.Context, opts GenerateTestsOptions) error {
+	targets, err := findUntestedChanges(opts)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		opts.Logger.Info("no changed functions without tests", "since", opts.Since)
+	}
+	for _, t := range targets {
+		opts.Logger.Info("generating test for changed function", "file", t.Filepath, "function", t.name())
+	}
+	return generateTargets(ctx, opts, targets)
+}
+
+// generateTargets generates tests for the targets and reports the results.
+// Failing functions are reported and skipped, so that one failure does not stop the others.
+func generateTargets(ctx context.Context, opts GenerateTestsOptions, targets []target) error {
+	results, errs := generateTests(ctx, opts, targets)
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			opts.Logger.Error("error generating test", "file", targets[i].Filepath, "function", targets[i].name(), "error", err)
+			results[i].Errors = append(results[i].Errors, err.Error())
+			failed = append(failed, targets[i].name())
+		}
+	}
+
+	if opts.OutputFormat == OutputFormatJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not generate tests for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// findUntestedChanges returns the functions of non-test files which changed since opts.Since
+// and have no corresponding test, ordered by file and position.
+func findUntestedChanges(opts GenerateTestsOptions) ([]target, error) {
+	changes, err := git.ChangedLines(".", opts.Since)
+	if err != nil {
+		return nil, fmt.Errorf("error finding changes since %q: %w", opts.Since, err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	onlyFile := ""
+	if opts.Filepath != "" {
+		onlyFile, err = filepath.Abs(opts.Filepath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := []string{}
+	for file := range changes {
+		if strings.HasSuffix(file, "_test.go") || (onlyFile != "" && file != onlyFile) {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	untested := []target{}
+	for _, file := range files {
+		settings, err := config.Resolve(file, opts.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("error loading configuration: %w", err)
+		}
+		if settings.Excluded(file) {
+			opts.Logger.Debug("skipping excluded file", "file", file)
+			continue
+		}
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		functions, err := parse.ChangedFunctions(code, changes[file])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q: %w", file, err)
+		}
+		if len(functions) == 0 {
+			continue
+		}
+		tests, err := parse.ListPackageTestFunctions(filepath.Dir(file))
+		if err != nil {
+			return nil, err
+		}
+		for _, function := range functions {
+			if !opts.Filter.selects(function) {
+				opts.Logger.Debug("changed function is filtered out", "file", file, "function", function.Name)
+				continue
+			}
+			if parse.HasTest(function, tests) {
+				opts.Logger.Debug("changed function already has a test", "file", file, "function", function.Name)
+				continue
+			}
+			untested = append(untested, target{Filepath: relativePath(wd, file), Receiver: function.Receiver, FunctionName: function.Name})
+		}
+	}
+	return untested, nil
+}
+
+// relativePath returns the path relative to dir, or the path itself if it is not within dir.
+func relativePath(dir string, path string) string {
+	relative, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return path
+	}
+	return relative
+}
@@ -1,16 +1,13 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
-	"path"
-	"time"
+	"path/filepath"
 
-	"github.com/briandowns/spinner"
-	"github.com/robotsail/go-create-test/pkg/lib"
-	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/config"
 	"github.com/robotsail/go-create-test/pkg/types"
 	"github.com/spf13/cobra"
 )
@@ -19,8 +16,30 @@ const (
 	FlagFilepathFull     = "filepath"
 	FlagFunctionNameFull = "function"
 	FlagProjectDirectory = "dir"
+	FlagStyleExamples    = "style-examples"
+	FlagDryRun           = "dry-run"
+	FlagOutput           = "output"
+	FlagInteractive      = "interactive"
+	FlagOutputFormat     = "output-format"
+	FlagVerify           = "verify"
+	FlagSince            = "since"
+	FlagMatch            = "match"
+	FlagExportedOnly     = "exported-only"
+	FlagSkip             = "skip"
 )
 
+// Output formats for the result of a command.
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+// stdoutOutput is the output path which writes the test file to stdout.
+const stdoutOutput = "-"
+
+// defaultStyleExamples is the number of existing tests included in the prompt as examples.
+const defaultStyleExamples = 3
+
 func NewGenerateTestCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "generate-tests",
@@ -29,15 +48,21 @@ func NewGenerateTestCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringP(FlagFilepathFull, "f", "", "path to the file containing the functions to be tested")
-	cmd.Flags().StringP(FlagFunctionNameFull, "n", "", "name of the function to be tested")
+	cmd.Flags().StringP(FlagFunctionNameFull, "n", "", "name of the function to be tested, qualified by its receiver type for a method, e.g. \"Server.Start\", or a position within it as file.go:line[:column]")
 	cmd.Flags().StringP(FlagProjectDirectory, "d", "", "path to the project directory (optional)")
-	requiredFlags := []string{FlagFilepathFull, FlagFunctionNameFull, FlagProjectDirectory}
-	for _, flag := range requiredFlags {
-		err := cmd.MarkFlagRequired(flag)
-		if err != nil {
-			log.Fatalf("error marking flag as required: %v", err)
-		}
-	}
+	cmd.Flags().Int(FlagStyleExamples, defaultStyleExamples, "number of existing tests in the package to include as examples (0 disables them)")
+	cmd.Flags().Bool(FlagDryRun, false, "print the test file, or a diff against the existing test file, instead of writing it")
+	cmd.Flags().StringP(FlagOutput, "o", "", "path to write the test file to, or \"-\" for stdout (defaults to the _test.go file next to the source file)")
+	cmd.Flags().BoolP(FlagInteractive, "i", false, "review each generated test function before it is written")
+	cmd.Flags().String(FlagOutputFormat, OutputFormatText, "format of the result, either \"text\" or \"json\"")
+	cmd.Flags().Bool(FlagVerify, false, "compile and run the generated tests after writing them")
+	cmd.Flags().String(FlagSince, "", "generate tests for the functions changed since the git ref which have no test yet, instead of a single function")
+	cmd.Flags().String(FlagMatch, "", "generate tests for the functions whose names match a glob, e.g. \"*Handler\", or a regular expression, e.g. \"^Parse\", within the file or the package in the current directory")
+	cmd.Flags().Bool(FlagExportedOnly, false, "only generate tests for exported functions when --match or --since is given")
+	cmd.Flags().StringSlice(FlagSkip, nil, "skip the functions whose names match any of the globs or regular expressions when --match or --since is given")
+	addLimitsFlags(cmd)
+	addCacheFlags(cmd)
+	addSettingsFlags(cmd)
 
 	return cmd
 }
@@ -45,7 +70,34 @@ func NewGenerateTestCmd() *cobra.Command {
 type GenerateTestsOptions struct {
 	Filepath     string
 	FunctionName string
-	ProjectDir   string
+	// Position is the position within Filepath of the function to be tested, when it is given instead of its name.
+	Position   *types.Position
+	ProjectDir string
+	// StyleExamples is the number of existing tests to include in the prompt as examples.
+	StyleExamples int
+	// DryRun prints the result instead of writing it.
+	DryRun bool
+	// Output overrides the path the test file is written to.
+	Output string
+	// Interactive lets the user review each generated test function.
+	Interactive bool
+	// OutputFormat is the format the result is reported in.
+	OutputFormat string
+	// Verify compiles and runs the generated tests.
+	Verify bool
+	// Since is the git ref whose changed functions tests are generated for.
+	Since string
+	// Filter selects the functions tests are generated for when --match or --since is given.
+	Filter targetFilter
+	// Limits limit how many tests are generated at once, and how fast.
+	Limits GenerationLimits
+	// NoCache disables the cache of responses of the model.
+	NoCache bool
+	// Overrides hold the settings given through the environment and flags,
+	// which take precedence over the configuration files.
+	Overrides config.Settings
+	// Logger receives the logs of every step of the generation.
+	Logger *slog.Logger
 }
 
 func parseGenerateTestsOptions(cmd *cobra.Command) (opts GenerateTestsOptions, err error) {
@@ -57,65 +109,156 @@ func parseGenerateTestsOptions(cmd *cobra.Command) (opts GenerateTestsOptions, e
 	if err != nil {
 		return
 	}
+	if file, position, ok := parseTargetPosition(opts.FunctionName); ok {
+		if opts.Filepath != "" && filepath.Clean(opts.Filepath) != filepath.Clean(file) {
+			err = fmt.Errorf("--%s %q refers to a different file than --%s %q", FlagFunctionNameFull, opts.FunctionName, FlagFilepathFull, opts.Filepath)
+			return
+		}
+		// the name is resolved once the project directory is entered
+		opts.Filepath, opts.FunctionName, opts.Position = file, "", &position
+	}
 	opts.ProjectDir, err = cmd.Flags().GetString(FlagProjectDirectory)
-	return
-}
-
-func RunGenerateTests(cmd *cobra.Command, args []string) error {
-	opts, err := parseGenerateTestsOptions(cmd)
 	if err != nil {
-		return err
+		return
 	}
-
-	err = os.Chdir(opts.ProjectDir)
+	opts.StyleExamples, err = cmd.Flags().GetInt(FlagStyleExamples)
 	if err != nil {
-		fmt.Printf("error changing directories: %v\n", err)
-		return err
+		return
 	}
-	// entry point
-	log.Printf("got %q and %q", opts.Filepath, opts.FunctionName)
-
-	code, err := ioutil.ReadFile(opts.Filepath)
+	opts.DryRun, err = cmd.Flags().GetBool(FlagDryRun)
 	if err != nil {
-		return err
+		return
 	}
-
-	packageName, err := parse.GetPackageName(code)
+	opts.Output, err = cmd.Flags().GetString(FlagOutput)
 	if err != nil {
-		return err
+		return
 	}
-	log.Printf("packageName: %q\n", packageName)
-
-	funcDef, err := parse.GetFunctionDefinition(opts.FunctionName, code)
+	opts.Interactive, err = cmd.Flags().GetBool(FlagInteractive)
 	if err != nil {
-		return err
+		return
+	}
+	opts.OutputFormat, err = cmd.Flags().GetString(FlagOutputFormat)
+	if err != nil {
+		return
+	}
+	if opts.OutputFormat != OutputFormatText && opts.OutputFormat != OutputFormatJSON {
+		err = fmt.Errorf("unknown output format %q", opts.OutputFormat)
+		return
+	}
+	opts.Verify, err = cmd.Flags().GetBool(FlagVerify)
+	if err != nil {
+		return
 	}
+	opts.Since, err = cmd.Flags().GetString(FlagSince)
+	if err != nil {
+		return
+	}
+	match, err := cmd.Flags().GetString(FlagMatch)
+	if err != nil {
+		return
+	}
+	if match != "" {
+		var pattern namePattern
+		pattern, err = compileNamePattern(match)
+		if err != nil {
+			return
+		}
+		opts.Filter.Match = &pattern
+	}
+	opts.Filter.ExportedOnly, err = cmd.Flags().GetBool(FlagExportedOnly)
+	if err != nil {
+		return
+	}
+	skip, err := cmd.Flags().GetStringSlice(FlagSkip)
+	if err != nil {
+		return
+	}
+	for _, s := range skip {
+		var pattern namePattern
+		pattern, err = compileNamePattern(s)
+		if err != nil {
+			return
+		}
+		opts.Filter.Skip = append(opts.Filter.Skip, pattern)
+	}
+	single := opts.FunctionName != "" || opts.Position != nil
+	switch {
+	case opts.Since != "" && single:
+		err = fmt.Errorf("--%s cannot be used together with --%s", FlagSince, FlagFunctionNameFull)
+		return
+	case match != "" && single:
+		err = fmt.Errorf("--%s cannot be used together with --%s", FlagMatch, FlagFunctionNameFull)
+		return
+	case match != "" && opts.Since != "":
+		err = fmt.Errorf("--%s cannot be used together with --%s", FlagMatch, FlagSince)
+		return
+	case single && (opts.Filter.ExportedOnly || len(opts.Filter.Skip) > 0):
+		err = fmt.Errorf("--%s and --%s only apply when --%s or --%s is given", FlagExportedOnly, FlagSkip, FlagMatch, FlagSince)
+		return
+	case opts.Since == "" && match == "" && (opts.Filepath == "" || !single):
+		err = fmt.Errorf("--%s and --%s are required unless --%s or --%s is given", FlagFilepathFull, FlagFunctionNameFull, FlagMatch, FlagSince)
+		return
+	}
+	opts.Limits, err = parseLimits(cmd)
+	if err != nil {
+		return
+	}
+	opts.NoCache, err = cmd.Flags().GetBool(FlagNoCache)
+	if err != nil {
+		return
+	}
+	opts.Overrides, err = parseSettingsOverrides(cmd)
+	if err != nil {
+		return
+	}
+	opts.Logger, err = parseLogger(cmd)
+	return
+}
 
-	callDefs, err := parse.GetFunctionCalls(opts.Filepath, opts.FunctionName, code)
+func RunGenerateTests(cmd *cobra.Command, args []string) error {
+	opts, err := parseGenerateTestsOptions(cmd)
 	if err != nil {
 		return err
 	}
 
-	testFileName := lib.GetTestFileName(opts.Filepath)
-	s := spinner.New(spinner.CharSets[20], 100*time.Millisecond) // Build our new spinner
-	s.Prefix = "Generating test code... "
-	s.FinalMSG = fmt.Sprintf("Done! Test file written to %s\n", testFileName)
-	s.Start() // Start the spinner
-	testFile, err := lib.GenerateTestCode(types.TestCodePrompt{
-		TargetFunction:  funcDef,
-		CalledFunctions: callDefs,
-		PackageName:     packageName,
-	})
-	s.Stop()
-	if err != nil {
-		return fmt.Errorf("error generating test code: %w", err)
+	if opts.ProjectDir != "" {
+		err = os.Chdir(opts.ProjectDir)
+		if err != nil {
+			fmt.Printf("error changing directories: %v\n", err)
+			return err
+		}
+	}
+	if opts.Since != "" {
+		return generateChangedTests(cmd.Context(), opts)
 	}
+	if opts.Filter.Match != nil {
+		return generateMatchedTests(cmd.Context(), opts)
+	}
+	if opts.Position != nil {
+		opts.FunctionName, err = resolveTargetPosition(opts.Filepath, *opts.Position)
+		if err != nil {
+			return err
+		}
+		opts.Logger.Debug("resolved target position", "file", opts.Filepath, "line", opts.Position.Line, "function", opts.FunctionName)
+	}
+	result, err := generateTest(cmd.Context(), opts)
+	if opts.OutputFormat == OutputFormatJSON {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		if encodeErr := printJSON(result); encodeErr != nil {
+			return encodeErr
+		}
+	}
+	return err
+}
 
-	sanitizedResponse := lib.UnwrapResponse(testFile)
-	fmt.Printf("")
-	err = ioutil.WriteFile(path.Join(path.Dir(opts.Filepath), testFileName), []byte(sanitizedResponse), 0644)
-	if err != nil {
-		return fmt.Errorf("error writing test file: %w", err)
+// printJSON writes the value to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("error encoding result: %w", err)
 	}
 	return nil
 }
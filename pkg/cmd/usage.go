@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// printUsageSummary writes the number of requests, tokens, and the estimated cost of a run to stderr,
+// unless the logs are quieted. Models without a known price are listed instead of being counted as free.
+func printUsageSummary(logger *slog.Logger, results []types.GenerationResult) {
+	if !logger.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+	requests, cached, promptTokens, completionTokens := 0, 0, 0, 0
+	cost := 0.0
+	unpriced := map[string]bool{}
+	for _, result := range results {
+		for _, request := range result.Requests {
+			requests++
+			if request.Cached {
+				cached++
+				continue
+			}
+			promptTokens += request.PromptTokens
+			completionTokens += request.CompletionTokens
+			cost += request.Cost
+			if request.Cost == 0 && request.PromptTokens+request.CompletionTokens > 0 {
+				unpriced[result.Model] = true
+			}
+		}
+	}
+	if requests == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Requests: %d (%d cached), tokens: %d prompt + %d completion, estimated cost: $%.4f",
+		requests, cached, promptTokens, completionTokens, cost)
+	if len(unpriced) > 0 {
+		models := []string{}
+		for model := range unpriced {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		fmt.Fprintf(os.Stderr, " (excluding %s, without a known price)", strings.Join(models, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// FileName is the name of the configuration file searched for in the project.
+const FileName = ".go-create-test.yaml"
+
+// Environment variables which override the values found in configuration files.
+const (
+	EnvModel            = "GO_CREATE_TEST_MODEL"
+	EnvProvider         = "GO_CREATE_TEST_PROVIDER"
+	EnvStyle            = "GO_CREATE_TEST_STYLE"
+	EnvAssertionLibrary = "GO_CREATE_TEST_ASSERTION_LIBRARY"
+)
+
+// Settings are the options which can be set from a configuration file, the environment, or flags.
+type Settings struct {
+	Model            string   `yaml:"model,omitempty"`
+	Provider         string   `yaml:"provider,omitempty"`
+	BaseURL          string   `yaml:"base_url,omitempty"`
+	Style            string   `yaml:"style,omitempty"`
+	AssertionLibrary string   `yaml:"assertion_library,omitempty"`
+	SystemPrompt     string   `yaml:"system_prompt,omitempty"`
+	Instructions     string   `yaml:"instructions,omitempty"`
+	Exclude          []string `yaml:"exclude,omitempty"`
+	// PromptTemplate and SystemPromptTemplate are paths to template files,
+	// relative to the configuration file which declares them.
+	PromptTemplate       string `yaml:"prompt_template,omitempty"`
+	SystemPromptTemplate string `yaml:"system_prompt_template,omitempty"`
+	// FullSource are import path patterns of packages outside the module whose definitions are included
+	// in the prompt in full, rather than summarized by their signature and the first sentence of their doc comment.
+	FullSource []string `yaml:"full_source,omitempty"`
+	// Prices are the prices of models, keyed by model name, used to estimate the cost of a run.
+	Prices map[string]types.Price `yaml:"prices,omitempty"`
+}
+
+// File is the contents of a single configuration file.
+// Packages maps package directories, relative to the file and optionally
+// containing glob patterns, to settings which only apply within them.
+type File struct {
+	Settings `yaml:",inline"`
+	Packages map[string]Settings `yaml:"packages,omitempty"`
+
+	// Dir is the directory containing the configuration file.
+	Dir string `yaml:"-"`
+}
+
+// Merge returns the settings with any values set in the override applied on top.
+// Exclude and full source patterns are accumulated rather than replaced, and prices are replaced per model.
+func (s Settings) Merge(override Settings) Settings {
+	merged := s
+	if override.Model != "" {
+		merged.Model = override.Model
+	}
+	if override.Provider != "" {
+		merged.Provider = override.Provider
+	}
+	if override.BaseURL != "" {
+		merged.BaseURL = override.BaseURL
+	}
+	if override.Style != "" {
+		merged.Style = override.Style
+	}
+	if override.AssertionLibrary != "" {
+		merged.AssertionLibrary = override.AssertionLibrary
+	}
+	if override.SystemPrompt != "" {
+		merged.SystemPrompt = override.SystemPrompt
+	}
+	if override.Instructions != "" {
+		merged.Instructions = override.Instructions
+	}
+	if override.PromptTemplate != "" {
+		merged.PromptTemplate = override.PromptTemplate
+	}
+	if override.SystemPromptTemplate != "" {
+		merged.SystemPromptTemplate = override.SystemPromptTemplate
+	}
+	merged.Exclude = append(append([]string{}, s.Exclude...), override.Exclude...)
+	merged.FullSource = append(append([]string{}, s.FullSource...), override.FullSource...)
+	if len(override.Prices) > 0 {
+		merged.Prices = map[string]types.Price{}
+		for model, price := range s.Prices {
+			merged.Prices[model] = price
+		}
+		for model, price := range override.Prices {
+			merged.Prices[model] = price
+		}
+	}
+	return merged
+}
+
+// Excluded reports whether the given file matches any of the exclude patterns.
+func (s Settings) Excluded(file string) bool {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range s.Exclude {
+		if ok, _ := filepath.Match(pattern, absPath); ok {
+			return true
+		}
+		// a pattern matching a directory excludes everything beneath it
+		if ok, _ := filepath.Match(pattern, filepath.Dir(absPath)); ok {
+			return true
+		}
+		if strings.HasPrefix(absPath, strings.TrimSuffix(pattern, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromEnv returns the settings given through environment variables.
+func FromEnv() Settings {
+	return Settings{
+		Model:            os.Getenv(EnvModel),
+		Provider:         os.Getenv(EnvProvider),
+		Style:            os.Getenv(EnvStyle),
+		AssertionLibrary: os.Getenv(EnvAssertionLibrary),
+	}
+}
+
+// Load reads the configuration file at the given path.
+func Load(path string) (File, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return File{}, fmt.Errorf("could not read config file: %w", err)
+	}
+	file := File{}
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return File{}, fmt.Errorf("could not parse config file %q: %w", path, err)
+	}
+	file.Dir, err = filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return File{}, fmt.Errorf("could not resolve config directory: %w", err)
+	}
+	// paths are relative to the file which declares them
+	file.Settings = file.Settings.absolutePaths(file.Dir)
+	for pattern, settings := range file.Packages {
+		file.Packages[pattern] = settings.absolutePaths(file.Dir)
+	}
+	return file, nil
+}
+
+// AbsolutePaths resolves the relative paths within the settings against the working directory.
+func (s Settings) AbsolutePaths() (Settings, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return Settings{}, fmt.Errorf("could not get working directory: %w", err)
+	}
+	return s.absolutePaths(wd), nil
+}
+
+// absolutePaths resolves the relative paths within the settings against the given directory.
+func (s Settings) absolutePaths(dir string) Settings {
+	s.Exclude = absolutePatterns(dir, s.Exclude)
+	if s.PromptTemplate != "" && !filepath.IsAbs(s.PromptTemplate) {
+		s.PromptTemplate = filepath.Join(dir, s.PromptTemplate)
+	}
+	if s.SystemPromptTemplate != "" && !filepath.IsAbs(s.SystemPromptTemplate) {
+		s.SystemPromptTemplate = filepath.Join(dir, s.SystemPromptTemplate)
+	}
+	return s
+}
+
+func absolutePatterns(dir string, patterns []string) []string {
+	absolute := []string{}
+	for _, pattern := range patterns {
+		if filepath.IsAbs(pattern) {
+			absolute = append(absolute, pattern)
+			continue
+		}
+		absolute = append(absolute, filepath.Join(dir, pattern))
+	}
+	return absolute
+}
+
+// Discover returns the configuration files which apply to the given directory,
+// ordered from the project root down to the directory itself.
+// The search walks upward and stops at the root of the git repository.
+func Discover(dir string) ([]File, error) {
+	current, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve directory: %w", err)
+	}
+	files := []File{}
+	for {
+		candidate := filepath.Join(current, FileName)
+		if _, err := os.Stat(candidate); err == nil {
+			file, err := Load(candidate)
+			if err != nil {
+				return nil, err
+			}
+			files = append([]File{file}, files...)
+		}
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+	return files, nil
+}
+
+// Resolve determines the settings for the given source file.
+// Values are applied in order of increasing precedence: the root configuration file,
+// its package overrides, nested configuration files down to the nearest one, and finally
+// the given overrides, which are expected to hold the values from the environment and flags.
+func Resolve(sourceFile string, overrides Settings) (Settings, error) {
+	dir, err := filepath.Abs(filepath.Dir(sourceFile))
+	if err != nil {
+		return Settings{}, fmt.Errorf("could not resolve directory: %w", err)
+	}
+	files, err := Discover(dir)
+	if err != nil {
+		return Settings{}, err
+	}
+	settings := Settings{}
+	for _, file := range files {
+		settings = settings.Merge(file.Settings)
+		// apply the least specific package patterns first
+		patterns := []string{}
+		for pattern := range file.Packages {
+			patterns = append(patterns, pattern)
+		}
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) < len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		for _, pattern := range patterns {
+			if file.matchesPackage(pattern, dir) {
+				settings = settings.Merge(file.Packages[pattern])
+			}
+		}
+	}
+	return settings.Merge(overrides), nil
+}
+
+// matchesPackage reports whether the package pattern of the file applies to the given directory.
+func (f File) matchesPackage(pattern string, dir string) bool {
+	rel, err := filepath.Rel(f.Dir, dir)
+	if err != nil {
+		return false
+	}
+	pattern = filepath.Clean(strings.TrimPrefix(pattern, "./"))
+	if ok, _ := filepath.Match(pattern, rel); ok {
+		return true
+	}
+	// patterns ending in '/...' match the package and all of its subpackages
+	if prefix := strings.TrimSuffix(pattern, string(filepath.Separator)+"..."); prefix != pattern {
+		return rel == prefix || strings.HasPrefix(rel, prefix+string(filepath.Separator))
+	}
+	return false
+}
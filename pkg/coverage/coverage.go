@@ -0,0 +1,236 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/parse"
+)
+
+// Block is a single block of statements within a coverage profile.
+type Block struct {
+	FileName  string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+// FunctionCoverage contains the coverage statistics of a single function.
+type FunctionCoverage struct {
+	Filepath   string
+	Name       string
+	Receiver   string
+	Statements int
+	Covered    int
+	Complexity int
+	// Profiled is false when the coverage profile has no blocks of the function, e.g. because its file
+	// was left out of the test build, in which case its coverage is unknown.
+	Profiled bool
+}
+
+// Uncovered returns the number of statements in the function which are not covered by any test.
+func (f FunctionCoverage) Uncovered() int {
+	return f.Statements - f.Covered
+}
+
+// Percent returns the percentage of statements in the function which are covered.
+// Functions whose coverage is unknown are reported as uncovered, so that they are not hidden from Gaps.
+func (f FunctionCoverage) Percent() float64 {
+	if !f.Profiled {
+		return 0
+	}
+	if f.Statements == 0 {
+		return 100
+	}
+	return 100 * float64(f.Covered) / float64(f.Statements)
+}
+
+// Run runs the tests of the package in the given directory with coverage enabled
+// and returns the blocks of the resulting coverage profile.
+func Run(logger *slog.Logger, packageDir string) ([]Block, error) {
+	profile, err := ioutil.TempFile("", "go-create-test-coverage-*.out")
+	if err != nil {
+		return nil, fmt.Errorf("could not create coverage profile: %w", err)
+	}
+	profile.Close()
+	defer os.Remove(profile.Name())
+
+	command := exec.Command("go", "test", "-covermode=set", "-coverprofile="+profile.Name(), ".")
+	command.Dir = packageDir
+	out, testErr := command.CombinedOutput()
+	if testErr != nil {
+		// failing tests still produce a profile for the tests which ran
+		logger.Warn("go test exited with an error", "error", testErr, "output", string(out))
+	}
+
+	file, err := os.Open(profile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("could not open coverage profile: %w", err)
+	}
+	defer file.Close()
+	blocks, err := ParseProfile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 && testErr != nil {
+		return nil, fmt.Errorf("go test did not produce a coverage profile: %w", testErr)
+	}
+	return blocks, nil
+}
+
+// ParseProfile parses a coverage profile as written by 'go test -coverprofile'.
+// Each line is in the format 'name.go:line.column,line.column numberOfStatements count'.
+func ParseProfile(r io.Reader) ([]Block, error) {
+	blocks := []Block{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		block, err := parseProfileLine(line)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read coverage profile: %w", err)
+	}
+	return blocks, nil
+}
+
+func parseProfileLine(line string) (Block, error) {
+	colon := strings.LastIndex(line, ":")
+	if colon == -1 {
+		return Block{}, fmt.Errorf("invalid profile line: %s", line)
+	}
+	fields := strings.Fields(line[colon+1:])
+	if len(fields) != 3 {
+		return Block{}, fmt.Errorf("invalid profile line: %s", line)
+	}
+	positions := strings.Split(fields[0], ",")
+	if len(positions) != 2 {
+		return Block{}, fmt.Errorf("invalid block range: %s", fields[0])
+	}
+	numbers := []int{}
+	for _, position := range positions {
+		for _, value := range strings.Split(position, ".") {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return Block{}, fmt.Errorf("invalid block position: %s", position)
+			}
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) != 4 {
+		return Block{}, fmt.Errorf("invalid block range: %s", fields[0])
+	}
+	numStmt, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Block{}, fmt.Errorf("invalid statement count: %s", fields[1])
+	}
+	count, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Block{}, fmt.Errorf("invalid hit count: %s", fields[2])
+	}
+	return Block{
+		FileName:  line[:colon],
+		StartLine: numbers[0],
+		StartCol:  numbers[1],
+		EndLine:   numbers[2],
+		EndCol:    numbers[3],
+		NumStmt:   numStmt,
+		Count:     count,
+	}, nil
+}
+
+// Functions attributes the given coverage blocks to the functions declared
+// in the non-test Go files of the package directory.
+func Functions(packageDir string, blocks []Block) ([]FunctionCoverage, error) {
+	// the profile refers to files by their import path, so match on the base name
+	blocksByFile := map[string][]Block{}
+	for _, block := range blocks {
+		name := path.Base(block.FileName)
+		blocksByFile[name] = append(blocksByFile[name], block)
+	}
+
+	files, err := filepath.Glob(filepath.Join(packageDir, "*.go"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list package files: %w", err)
+	}
+	functions := []FunctionCoverage{}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file: %w", err)
+		}
+		declarations, err := parse.ListFunctions(code)
+		if err != nil {
+			return nil, fmt.Errorf("could not list functions in %q: %w", file, err)
+		}
+		for _, decl := range declarations {
+			function := FunctionCoverage{
+				Filepath:   file,
+				Name:       decl.Name,
+				Receiver:   decl.Receiver,
+				Complexity: decl.Complexity,
+			}
+			// tree-sitter rows are zero-indexed while profile lines start at one
+			startLine := int(decl.Range.Start.Row) + 1
+			endLine := int(decl.Range.End.Row) + 1
+			for _, block := range blocksByFile[filepath.Base(file)] {
+				if block.StartLine < startLine || block.EndLine > endLine {
+					continue
+				}
+				function.Profiled = true
+				function.Statements += block.NumStmt
+				if block.Count > 0 {
+					function.Covered += block.NumStmt
+				}
+			}
+			functions = append(functions, function)
+		}
+	}
+	return functions, nil
+}
+
+// Gaps returns the functions whose coverage is below the threshold percentage,
+// ordered by the number of uncovered statements and then by complexity.
+func Gaps(functions []FunctionCoverage, threshold float64) []FunctionCoverage {
+	gaps := []FunctionCoverage{}
+	for _, function := range functions {
+		if function.Percent() < threshold {
+			gaps = append(gaps, function)
+		}
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].Uncovered() != gaps[j].Uncovered() {
+			return gaps[i].Uncovered() > gaps[j].Uncovered()
+		}
+		if gaps[i].Complexity != gaps[j].Complexity {
+			return gaps[i].Complexity > gaps[j].Complexity
+		}
+		if gaps[i].Filepath != gaps[j].Filepath {
+			return gaps[i].Filepath < gaps[j].Filepath
+		}
+		return gaps[i].Name < gaps[j].Name
+	})
+	return gaps
+}
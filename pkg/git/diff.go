@@ -0,0 +1,198 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// Root returns the top-level directory of the repository containing dir.
+func Root(dir string) (string, error) {
+	out, err := run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ChangedLines returns the lines of the Go files in the repository containing dir
+// which differ between the working tree and the given ref, keyed by absolute file path.
+// Untracked files are reported as changed in their entirety.
+func ChangedLines(dir string, ref string) (map[string][]types.LineRange, error) {
+	root, err := Root(dir)
+	if err != nil {
+		return nil, err
+	}
+	// the prefixes are given explicitly, since diff.noprefix and diff.mnemonicPrefix change them
+	out, err := run(root, "diff", "--unified=0", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/", ref, "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	changes, err := ParseDiff(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse diff against %q: %w", ref, err)
+	}
+
+	// paths are separated by NUL rather than quoted
+	out, err = run(root, "ls-files", "-z", "--others", "--exclude-standard", "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file != "" {
+			changes[file] = []types.LineRange{{Start: 1, End: int(^uint(0) >> 1)}}
+		}
+	}
+
+	absolute := map[string][]types.LineRange{}
+	for file, lines := range changes {
+		absolute[filepath.Join(root, file)] = lines
+	}
+	return absolute, nil
+}
+
+// StagedFiles returns the absolute paths of the Go files staged in the repository containing dir,
+// excluding deleted files.
+func StagedFiles(dir string) ([]string, error) {
+	root, err := Root(dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := run(root, "diff", "--cached", "-z", "--name-only", "--diff-filter=ACMR", "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file != "" {
+			files = append(files, filepath.Join(root, file))
+		}
+	}
+	return files, nil
+}
+
+// StagedContent returns the content of the given file as it is staged in the index of its repository,
+// which differs from the working tree when only some of its changes are staged.
+func StagedContent(file string) ([]byte, error) {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve file: %w", err)
+	}
+	root, err := Root(filepath.Dir(absPath))
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, absPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve file within the repository: %w", err)
+	}
+	// paths after ':' are relative to the top-level directory
+	return run(root, "show", ":"+filepath.ToSlash(rel))
+}
+
+// HooksDir returns the absolute path of the hooks directory of the repository containing dir,
+// taking worktrees and the core.hooksPath setting into account.
+func HooksDir(dir string) (string, error) {
+	out, err := run(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooks := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(dir, hooks)
+	}
+	return filepath.Abs(hooks)
+}
+
+// ParseDiff reads a unified diff, as produced by 'git diff --unified=0',
+// and returns the changed line ranges of the new version of every file.
+// A hunk which only removes lines is reported as a change of the lines around the removal.
+func ParseDiff(r io.Reader) (map[string][]types.LineRange, error) {
+	changes := map[string][]types.LineRange{}
+	file := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = diffPath(strings.TrimPrefix(line, "+++ "))
+			if file == "/dev/null" {
+				// deleted files have nothing left to test
+				file = ""
+				continue
+			}
+			file = strings.TrimPrefix(file, "b/")
+		case strings.HasPrefix(line, "@@ ") && file != "":
+			lines, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			changes[file] = append(changes[file], lines)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffPath returns the path of a file header line of a diff. Paths with unusual characters,
+// such as '"b/na\303\257ve.go"', are quoted, and paths containing spaces may be followed by a tab.
+func diffPath(path string) string {
+	path = strings.TrimSuffix(path, "\t")
+	if strings.HasPrefix(path, `"`) {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// parseHunkHeader returns the lines of the new file covered by a hunk header in the format '@@ -a,b +c,d @@'.
+func parseHunkHeader(header string) (types.LineRange, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return types.LineRange{}, fmt.Errorf("invalid hunk header %q", header)
+	}
+	start, count := strings.TrimPrefix(fields[2], "+"), "1"
+	if i := strings.Index(start, ","); i != -1 {
+		start, count = start[:i], start[i+1:]
+	}
+	startLine, err := strconv.Atoi(start)
+	if err != nil {
+		return types.LineRange{}, fmt.Errorf("invalid hunk header %q: %w", header, err)
+	}
+	countLines, err := strconv.Atoi(count)
+	if err != nil {
+		return types.LineRange{}, fmt.Errorf("invalid hunk header %q: %w", header, err)
+	}
+	if countLines == 0 {
+		// the lines were removed after startLine
+		if startLine == 0 {
+			return types.LineRange{Start: 1, End: 1}, nil
+		}
+		return types.LineRange{Start: startLine, End: startLine + 1}, nil
+	}
+	return types.LineRange{Start: startLine, End: startLine + countLines - 1}, nil
+}
+
+func run(dir string, args ...string) ([]byte, error) {
+	command := exec.Command("git", args...)
+	command.Dir = dir
+	out, err := command.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("error running git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("error running git %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -2,86 +2,331 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
-	"text/template"
+	"time"
 
+	"github.com/robotsail/go-create-test/pkg/cache"
 	"github.com/robotsail/go-create-test/pkg/types"
 	openai "github.com/sashabaranov/go-openai"
 )
 
-const systemPrompt = `You are a highly skilled machine that writes Golang tests for a living.
-You process requests to write test code for a given function and are provided with all of the definitions that the target function calls.
-Given a request to test a function, respond only with the code for the entire test file.
-`
-
-const prompt = `
-You must write a Golang test for the following function:
+// Supported providers for generating test code.
+const (
+	ProviderOpenAI = "openai"
+	ProviderAzure  = "azure"
+)
 
-` + "```" + `go
-package {{.PackageName}}
+// DefaultModel is the model used when none is configured.
+const DefaultModel = openai.GPT4
 
-// rest of the file omitted for brevity
+// Parameters of every completion request.
+const (
+	// maxTokens is the maximum number of tokens of a completion.
+	maxTokens   = 1024
+	temperature = 0.1
+)
 
-{{.TargetFunction}}
-` + "```" + `
+// newClient creates a client for the configured provider.
+func newClient(opts types.GenerationOptions) (*openai.Client, error) {
+	switch opts.Provider {
+	case "", ProviderOpenAI:
+		apiKey, ok := os.LookupEnv("OPENAI_API_KEY")
+		if !ok {
+			return nil, fmt.Errorf("missing required environment variable: OPENAI_API_KEY")
+		}
+		config := openai.DefaultConfig(apiKey)
+		if opts.BaseURL != "" {
+			config.BaseURL = opts.BaseURL
+		}
+		config.HTTPClient = newHTTPClient(opts)
+		return openai.NewClientWithConfig(config), nil
+	case ProviderAzure:
+		apiKey, ok := os.LookupEnv("AZURE_OPENAI_API_KEY")
+		if !ok {
+			return nil, fmt.Errorf("missing required environment variable: AZURE_OPENAI_API_KEY")
+		}
+		if opts.BaseURL == "" || opts.Model == "" {
+			return nil, fmt.Errorf("the azure provider requires a base URL and a model deployment name")
+		}
+		// azure addresses models by their deployment name
+		config := openai.DefaultAzureConfig(apiKey, opts.BaseURL, opts.Model)
+		config.HTTPClient = newHTTPClient(opts)
+		return openai.NewClientWithConfig(config), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", opts.Provider)
+}
 
-For context, here are definitions for all of the symbols referenced by the target functions. Use these definitions 
-to properly test for any edge cases or fail points.
+// newHTTPClient creates the HTTP client used to reach the provider, which retries failed requests.
+func newHTTPClient(opts types.GenerationOptions) *http.Client {
+	return &http.Client{
+		Transport: &retryTransport{
+			base:       http.DefaultTransport,
+			maxRetries: opts.MaxRetries,
+			timeout:    opts.RequestTimeout,
+			logger:     opts.Logger,
+		},
+	}
+}
 
-` + "```" + `go
-{{.CalledFunctions}}
-` + "```" + `
+// Conversation is an exchange of messages with the model about a single test file,
+// which allows follow-up requests such as regenerating a test with feedback.
+type Conversation struct {
+	client   *openai.Client
+	model    string
+	provider string
+	baseURL  string
+	Messages []openai.ChatCompletionMessage
+	// Usage is the total number of tokens used by the conversation so far.
+	Usage openai.Usage
+	// Limiter, if set, delays requests to stay within the rate limits.
+	Limiter *RateLimiter
+	// Cache, if set, answers requests which were sent before without sending them again.
+	Cache *cache.Cache
+	// CacheHits is the number of replies of the conversation which were answered by the cache.
+	CacheHits int
+	// Requests are the usage of every request of the conversation, including those answered by the cache.
+	Requests []types.RequestUsage
+	// Budget, if set, refuses requests which could exceed the maximum cost of the run.
+	Budget *Budget
+	// Stream, if set, receives the replies of the model as they are generated.
+	Stream io.Writer
 
-`
+	price      types.Price
+	knownPrice bool
+}
 
-func createTestPrompt(params types.TestCodePrompt) (string, error) {
-	tmpl := template.Must(template.New("prompt").Parse(prompt))
+// Model returns the model the conversation is held with.
+func (c *Conversation) Model() string {
+	return c.model
+}
 
-	// Execute the template with the given data
-	var output strings.Builder
-	err := tmpl.Execute(&output, params)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+// Cost returns the estimated cost of the conversation so far in US dollars,
+// or zero when the price of the model is unknown.
+func (c *Conversation) Cost() float64 {
+	total := 0.0
+	for _, request := range c.Requests {
+		total += request.Cost
 	}
-
-	// Return the formatted data as a string
-	return output.String(), nil
+	return total
 }
 
-func GenerateTestCode(params types.TestCodePrompt) (string, error) {
-	prompt, err := createTestPrompt(params)
+// NewConversation starts a conversation whose first messages are the rendered prompt templates.
+func NewConversation(opts types.GenerationOptions, templates PromptTemplates, params types.TestCodePrompt) (*Conversation, error) {
+	system, err := executeTemplate(templates.System, params)
 	if err != nil {
-		return "", fmt.Errorf("failed to create prompt: %w", err)
+		return nil, fmt.Errorf("failed to create system prompt: %w", err)
 	}
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	client := openai.NewClient(apiKey)
-
-	res, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: openai.GPT4,
+	prompt, err := executeTemplate(templates.Prompt, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prompt: %w", err)
+	}
+	client, err := newClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	model := opts.Model
+	if model == "" {
+		model = DefaultModel
+	}
+	provider := opts.Provider
+	if provider == "" {
+		provider = ProviderOpenAI
+	}
+	price, knownPrice := FindPrice(opts.Prices, model)
+	return &Conversation{
+		client:     client,
+		model:      model,
+		provider:   provider,
+		baseURL:    opts.BaseURL,
+		price:      price,
+		knownPrice: knownPrice,
 		Messages: []openai.ChatCompletionMessage{
 			{
-				Role:    "system",
-				Content: systemPrompt,
+				Role:    openai.ChatMessageRoleSystem,
+				Content: system,
 			},
 			{
-				Role:    "user",
+				Role:    openai.ChatMessageRoleUser,
 				Content: prompt,
 			},
 		},
-		MaxTokens:   1024,
-		Temperature: 0.1,
+	}, nil
+}
+
+// Send requests a completion of the conversation so far, and records the model's reply in it.
+// Cancelling the context stops the request, including any retries.
+func (c *Conversation) Send(ctx context.Context) (string, error) {
+	key := c.cacheKey()
+	if c.Cache != nil {
+		if entry, ok := c.Cache.Get(key); ok {
+			c.CacheHits++
+			c.Requests = append(c.Requests, types.RequestUsage{Cached: true})
+			c.Messages = append(c.Messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: entry.Response,
+			})
+			return entry.Response, nil
+		}
+	}
+
+	promptEstimate := c.estimatePromptTokens()
+	estimate := promptEstimate + maxTokens
+	reserved := 0.0
+	if c.Budget != nil {
+		if !c.knownPrice {
+			return "", fmt.Errorf("the price of model %q is unknown, add it to the prices in the configuration to limit the cost", c.model)
+		}
+		reserved = Cost(c.price, promptEstimate, maxTokens)
+		if err := c.Budget.Reserve(reserved); err != nil {
+			return "", err
+		}
+	}
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx, estimate); err != nil {
+			c.Budget.Commit(reserved, 0)
+			return "", fmt.Errorf("failed waiting for rate limit: %w", err)
+		}
+	}
+	var content string
+	var usage openai.Usage
+	var err error
+	if c.Stream != nil {
+		content, usage, err = c.completeStream(ctx, promptEstimate)
+	} else {
+		content, usage, err = c.complete(ctx)
+	}
+	if err != nil {
+		c.Budget.Commit(reserved, 0)
+		return "", err
+	}
+	if c.Limiter != nil {
+		c.Limiter.Adjust(usage.TotalTokens - estimate)
+	}
+	cost := 0.0
+	if c.knownPrice {
+		cost = Cost(c.price, usage.PromptTokens, usage.CompletionTokens)
+	}
+	c.Budget.Commit(reserved, cost)
+	c.Requests = append(c.Requests, types.RequestUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Estimated:        c.Stream != nil,
+		Cost:             cost,
+	})
+	c.Usage.PromptTokens += usage.PromptTokens
+	c.Usage.CompletionTokens += usage.CompletionTokens
+	c.Usage.TotalTokens += usage.TotalTokens
+	c.Messages = append(c.Messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleAssistant,
+		Content: content,
 	})
+	if c.Cache != nil {
+		// the response was paid for, so failing to cache it must not lose it
+		_ = c.Cache.Put(key, cache.Entry{
+			Model:            c.model,
+			Response:         content,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			Created:          time.Now(),
+		})
+	}
+	return content, nil
+}
+
+// request returns the request for a completion of the conversation so far.
+func (c *Conversation) request() openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:       c.model,
+		Messages:    c.Messages,
+		MaxTokens:   maxTokens,
+		Temperature: temperature,
+	}
+}
+
+// complete requests a completion and returns the model's reply once it is generated.
+func (c *Conversation) complete(ctx context.Context) (string, openai.Usage, error) {
+	res, err := c.client.CreateChatCompletion(ctx, c.request())
 	if err != nil {
-		return "", fmt.Errorf("failed to create completion: %w", err)
+		return "", openai.Usage{}, fmt.Errorf("failed to create completion: %w", err)
 	}
 	// extract response
 	if len(res.Choices) == 0 {
-		return "", fmt.Errorf("no choices returned")
+		return "", res.Usage, fmt.Errorf("no choices returned")
+	}
+	return res.Choices[0].Message.Content, res.Usage, nil
+}
+
+// completeStream requests a completion and writes the model's reply to c.Stream as it is generated.
+// Streamed completions do not report their usage, so the prompt tokens are estimated
+// and every chunk of the reply is counted as a single token.
+func (c *Conversation) completeStream(ctx context.Context, promptEstimate int) (string, openai.Usage, error) {
+	stream, err := c.client.CreateChatCompletionStream(ctx, c.request())
+	if err != nil {
+		return "", openai.Usage{}, fmt.Errorf("failed to create completion: %w", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	chunks := 0
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", openai.Usage{}, fmt.Errorf("failed to receive completion: %w", err)
+		}
+		if len(res.Choices) == 0 || res.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := res.Choices[0].Delta.Content
+		chunks++
+		content.WriteString(delta)
+		// failing to show the reply must not lose it
+		_, _ = io.WriteString(c.Stream, delta)
+	}
+	if content.Len() == 0 {
+		return "", openai.Usage{}, fmt.Errorf("no choices returned")
+	}
+	return content.String(), openai.Usage{
+		PromptTokens:     promptEstimate,
+		CompletionTokens: chunks,
+		TotalTokens:      promptEstimate + chunks,
+	}, nil
+}
+
+// cacheKey identifies a request by the provider and endpoint, the model, the request parameters, and every message so far.
+// Models of the same name may differ between endpoints, such as Azure deployments or local servers.
+func (c *Conversation) cacheKey() string {
+	parts := []string{c.provider, c.baseURL, c.model, strconv.Itoa(maxTokens), strconv.FormatFloat(temperature, 'f', -1, 64)}
+	for _, message := range c.Messages {
+		parts = append(parts, message.Role, message.Content)
 	}
-	generation := res.Choices[0]
-	return generation.Message.Content, nil
+	return cache.Key(parts...)
+}
+
+// estimatePromptTokens estimates the tokens of the prompt before it is sent, assuming four characters per token.
+func (c *Conversation) estimatePromptTokens() int {
+	characters := 0
+	for _, message := range c.Messages {
+		characters += len(message.Content)
+	}
+	return characters / 4
+}
+
+// Reply adds a message from the user to the conversation and returns the model's answer.
+func (c *Conversation) Reply(ctx context.Context, message string) (string, error) {
+	c.Messages = append(c.Messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: message,
+	})
+	return c.Send(ctx)
 }
 
 // UnwrapResponse accepts a piece of code which is enclosed within two backtick blocks, like '```\nfoo\n```'.
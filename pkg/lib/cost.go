@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// DefaultPrices are the prices of OpenAI's models, used for the models without a configured price.
+var DefaultPrices = map[string]types.Price{
+	openai.GPT4:          {Prompt: 0.03, Completion: 0.06},
+	openai.GPT432K:       {Prompt: 0.06, Completion: 0.12},
+	openai.GPT3Dot5Turbo: {Prompt: 0.0015, Completion: 0.002},
+}
+
+// ErrBudgetExceeded is returned for requests which could raise the cost of the run above its budget.
+var ErrBudgetExceeded = errors.New("the request could exceed the maximum cost")
+
+// FindPrice returns the price of the model, preferring the configured prices over the built-in ones.
+// Models without a price of their own, such as dated snapshots like 'gpt-4-0613', use the price
+// of the longest model name they start with.
+func FindPrice(prices map[string]types.Price, model string) (types.Price, bool) {
+	for _, table := range []map[string]types.Price{prices, DefaultPrices} {
+		if price, ok := table[model]; ok {
+			return price, true
+		}
+	}
+	for _, table := range []map[string]types.Price{prices, DefaultPrices} {
+		longest := ""
+		for name := range table {
+			if strings.HasPrefix(model, name+"-") && len(name) > len(longest) {
+				longest = name
+			}
+		}
+		if longest != "" {
+			return table[longest], true
+		}
+	}
+	return types.Price{}, false
+}
+
+// Cost returns the cost in US dollars of a request with the given number of tokens.
+func Cost(price types.Price, promptTokens int, completionTokens int) float64 {
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1000
+}
+
+// Budget limits the total cost of the requests of a run, shared between its conversations.
+// Requests reserve their highest possible cost before they are sent, so that concurrent
+// requests cannot exceed the budget together.
+type Budget struct {
+	mu       sync.Mutex
+	max      float64
+	spent    float64
+	reserved float64
+	// exhausted is set once a request was refused, so that no further work is started.
+	exhausted bool
+}
+
+// NewBudget creates a budget of the given cost in US dollars.
+func NewBudget(max float64) *Budget {
+	return &Budget{max: max}
+}
+
+// Reserve sets aside the given cost for a request, or returns ErrBudgetExceeded if the total
+// could exceed the budget. The reservation must be released through Commit.
+func (b *Budget) Reserve(cost float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spent+b.reserved+cost > b.max {
+		b.exhausted = true
+		return fmt.Errorf("%w of $%.4f, $%.4f were spent", ErrBudgetExceeded, b.max, b.spent)
+	}
+	b.reserved += cost
+	return nil
+}
+
+// Commit replaces a reservation with the actual cost of the request.
+// Committing to a nil budget does nothing.
+func (b *Budget) Commit(reserved float64, actual float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reserved -= reserved
+	b.spent += actual
+}
+
+// Exhausted reports whether a request was refused for exceeding the budget.
+func (b *Budget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exhausted
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TestFile is a test file split into its top-level declarations, so that
+// individual declarations can be reviewed, replaced, or dropped.
+type TestFile struct {
+	Package string
+	Imports []*ast.ImportSpec
+	Decls   []TestDecl
+}
+
+// TestDecl is a single top-level declaration of a test file.
+type TestDecl struct {
+	// Name is the name of the declaration, or empty for declarations which declare no single name.
+	Name string
+	// IsFunc is true for function declarations.
+	IsFunc bool
+	// Source is the declaration, including its doc comment.
+	Source string
+}
+
+// SplitTestFile parses the source of a test file into its declarations.
+func SplitTestFile(src []byte) (TestFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "generated.go", src, parser.ParseComments)
+	if err != nil {
+		return TestFile{}, fmt.Errorf("could not parse test file: %w", err)
+	}
+	testFile := TestFile{
+		Package: file.Name.Name,
+		Imports: file.Imports,
+	}
+	for _, decl := range file.Decls {
+		if isImportDecl(decl) {
+			continue
+		}
+		names := declNames(decl)
+		name := ""
+		if len(names) == 1 {
+			name = names[0]
+		}
+		_, isFunc := decl.(*ast.FuncDecl)
+		testFile.Decls = append(testFile.Decls, TestDecl{
+			Name:   name,
+			IsFunc: isFunc,
+			Source: string(sourceOf(fset, src, decl)),
+		})
+	}
+	return testFile, nil
+}
+
+// Find returns the index of the declaration with the given name, or -1 if there is none.
+func (f TestFile) Find(name string) int {
+	for i, decl := range f.Decls {
+		if decl.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Source assembles the declarations back into a formatted test file.
+// Imports which are no longer referenced by any of the declarations are dropped.
+func (f TestFile) Source() ([]byte, error) {
+	var body bytes.Buffer
+	for _, decl := range f.Decls {
+		body.WriteString("\n\n" + decl.Source)
+	}
+
+	used, err := selectorQualifiers(body.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not format test file: %w", err)
+	}
+	seen := map[string]bool{}
+	specs := []string{}
+	for _, spec := range usedImports(f.Imports, used) {
+		line := spec.Path.Value
+		if spec.Name != nil {
+			line = spec.Name.Name + " " + line
+		}
+		if !seen[line] {
+			seen[line] = true
+			specs = append(specs, line)
+		}
+	}
+
+	src := "package " + f.Package + "\n\n" + importDecl(specs) + body.String() + "\n"
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		return nil, fmt.Errorf("could not format test file: %w", err)
+	}
+	return formatted, nil
+}
+
+// selectorQualifiers returns the identifiers used as the left-hand side of a selector within the given declarations,
+// e.g. 'strings' for 'strings.ToUpper'. The declarations are parsed, so that selectors within comments and string
+// literals are not counted.
+func selectorQualifiers(decls string) (map[string]bool, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+decls, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse declarations: %w", err)
+	}
+	used := map[string]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := selector.X.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+	return used, nil
+}
+
+// versionSuffix matches major version suffixes of import paths, such as 'v3' or 'yaml.v3'.
+var versionSuffix = regexp.MustCompile(`^v[0-9]+$|\.v[0-9]+$`)
+
+// usedImports returns the imports referred to by any of the used qualifiers, along with the blank and dot imports.
+// Explicit import names take precedence over the names guessed for other imports, e.g. of
+// 'yaml "gopkg.in/yaml.v3"' and '"gopkg.in/yaml.v2"', only the former is used by 'yaml.Marshal'.
+func usedImports(specs []*ast.ImportSpec, used map[string]bool) []*ast.ImportSpec {
+	explicit := map[string]bool{}
+	for _, spec := range specs {
+		if spec.Name != nil {
+			explicit[spec.Name.Name] = true
+		}
+	}
+	kept := []*ast.ImportSpec{}
+	for _, spec := range specs {
+		for _, name := range importNames(spec) {
+			if name == "_" || name == "." || (used[name] && (spec.Name != nil || !explicit[name])) {
+				kept = append(kept, spec)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// importNames returns the names an import may be referred to by: its explicit name if it has one, and otherwise
+// the names its package may have, guessed from its path. Since a path ending in a major version such as
+// 'k8s.io/api/core/v1' may name either package 'v1' or 'core', both are returned.
+func importNames(spec *ast.ImportSpec) []string {
+	if spec.Name != nil {
+		return []string{spec.Name.Name}
+	}
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return nil
+	}
+	parts := strings.Split(importPath, "/")
+	last := parts[len(parts)-1]
+	names := []string{last}
+	if versionSuffix.MatchString(last) && len(parts) > 1 && !strings.Contains(last, ".") {
+		last = parts[len(parts)-2]
+	}
+	name := versionSuffix.ReplaceAllString(last, "")
+	name = strings.TrimPrefix(name, "go-")
+	return append(names, strings.ReplaceAll(name, "-", ""))
+}
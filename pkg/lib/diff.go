@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-', or '+'
+	line string
+}
+
+// UnifiedDiff returns a unified diff which transforms before into after.
+// An empty string is returned when the contents are identical.
+func UnifiedDiff(beforeName, afterName, before, after string) string {
+	if before == after {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", beforeName, afterName)
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// extend the hunk until there are more than twice the context lines without a change
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && ops[end-1].kind == ' ' {
+			end--
+		}
+		hunkStart := start - diffContext
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + diffContext
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+		writeHunk(&out, ops, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []diffOp, start, end int) {
+	// line numbers of the hunk are the lines preceding it in each file, plus one
+	beforeLine, afterLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			beforeLine++
+		}
+		if op.kind != '-' {
+			afterLine++
+		}
+	}
+	beforeCount, afterCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			beforeCount++
+		}
+		if op.kind != '-' {
+			afterCount++
+		}
+	}
+	if beforeCount == 0 {
+		beforeLine--
+	}
+	if afterCount == 0 {
+		afterLine--
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", beforeLine, beforeCount, afterLine, afterCount)
+	for _, op := range ops[start:end] {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		out.WriteByte('\n')
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes the edit script between the two lists of lines using their longest common subsequence.
+func diffLines(before, after []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of before[i:] and after[j:]
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			ops = append(ops, diffOp{' ', before[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', before[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', after[j]})
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		ops = append(ops, diffOp{'-', before[i]})
+	}
+	for ; j < len(after); j++ {
+		ops = append(ops, diffOp{'+', after[j]})
+	}
+	return ops
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// ANSI escape sequences used to highlight Go source in the terminal.
+const (
+	colorReset   = "\033[0m"
+	colorKeyword = "\033[1;34m"
+	colorString  = "\033[32m"
+	colorNumber  = "\033[35m"
+	colorComment = "\033[90m"
+	colorFunc    = "\033[33m"
+)
+
+// Highlight returns the Go source with ANSI escape sequences for syntax highlighting.
+// Source which cannot be tokenized is returned from that point on without highlighting.
+func Highlight(src string) string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	var out strings.Builder
+	offset := 0
+	previous := token.ILLEGAL
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		start := file.Offset(pos)
+		// automatically inserted semicolons do not appear in the source
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+		end := start + len(text)
+		if start < offset || end > len(src) {
+			continue
+		}
+		out.WriteString(src[offset:start])
+
+		color := ""
+		switch {
+		case tok.IsKeyword():
+			color = colorKeyword
+		case tok == token.STRING || tok == token.CHAR:
+			color = colorString
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			color = colorNumber
+		case tok == token.COMMENT:
+			color = colorComment
+		case tok == token.IDENT && previous == token.FUNC:
+			color = colorFunc
+		}
+		if color != "" {
+			out.WriteString(color + src[start:end] + colorReset)
+		} else {
+			out.WriteString(src[start:end])
+		}
+		offset = end
+		previous = tok
+	}
+	out.WriteString(src[offset:])
+	return out.String()
+}
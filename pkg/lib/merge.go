@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// MergeTestFile merges the declarations of a generated test file into an existing one.
+// Any top-level declaration in the generated file whose name is not already declared in the
+// existing file is appended to it, and only the generated imports these declarations use are added.
+// The merged file is returned formatted.
+func MergeTestFile(existing, generated []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(existing)) == 0 {
+		return format.Source(generated)
+	}
+
+	fset := token.NewFileSet()
+	existingFile, err := parser.ParseFile(fset, "existing.go", existing, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse existing test file: %w", err)
+	}
+	generatedFile, err := parser.ParseFile(fset, "generated.go", generated, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse generated test file: %w", err)
+	}
+	if existingFile.Name.Name != generatedFile.Name.Name {
+		return nil, fmt.Errorf("generated package %q does not match existing package %q", generatedFile.Name.Name, existingFile.Name.Name)
+	}
+
+	declared := map[string]bool{}
+	for _, decl := range existingFile.Decls {
+		for _, name := range declNames(decl) {
+			declared[name] = true
+		}
+	}
+
+	// collect the new declarations from the generated file, along with their doc comments
+	newDecls := []string{}
+	for _, decl := range generatedFile.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			continue
+		}
+		src, ok := newDeclSource(fset, generated, decl, declared)
+		if ok {
+			newDecls = append(newDecls, src)
+		}
+	}
+
+	// only add the generated imports which the new declarations refer to
+	used, err := selectorQualifiers(strings.Join(newDecls, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse generated declarations: %w", err)
+	}
+	imports := mergeImports(existingFile.Imports, usedImports(generatedFile.Imports, used))
+
+	// rebuild the existing file with the merged import block, unless no imports were added
+	var merged bytes.Buffer
+	offset := 0
+	if len(imports) == len(existingFile.Imports) {
+		imports = nil
+	}
+	for i, decl := range existingFile.Decls {
+		if imports == nil {
+			break
+		}
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		start := fset.Position(gen.Pos()).Offset
+		end := fset.Position(gen.End()).Offset
+		merged.Write(existing[offset:start])
+		if i == 0 || !isImportDecl(existingFile.Decls[i-1]) {
+			merged.WriteString(importDecl(imports))
+		}
+		offset = end
+	}
+	if offset == 0 && imports != nil {
+		// the existing file has no imports, so place them after the package clause
+		offset = fset.Position(existingFile.Name.End()).Offset
+		merged.Write(existing[:offset])
+		merged.WriteString("\n\n" + importDecl(imports))
+	}
+	merged.Write(existing[offset:])
+	for _, decl := range newDecls {
+		merged.WriteString("\n\n" + decl)
+	}
+	merged.WriteString("\n")
+
+	formatted, err := format.Source(merged.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not format merged test file: %w", err)
+	}
+	return formatted, nil
+}
+
+// declNames returns the names declared by a top-level declaration.
+func declNames(decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return []string{receiverName(d.Recv.List[0].Type) + "." + d.Name.Name}
+		}
+		return []string{d.Name.Name}
+	case *ast.GenDecl:
+		names := []string{}
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// newDeclSource returns the source of the parts of a generated declaration which are not already declared,
+// and false if nothing is left of it. Type specs and var specs whose names are all declared are left out,
+// and the declared names of other value specs are replaced with the blank identifier, which keeps
+// the values of iota and of implicitly repeated constants unchanged.
+func newDeclSource(fset *token.FileSet, source []byte, decl ast.Decl, declared map[string]bool) (string, bool) {
+	names := declNames(decl)
+	fresh := 0
+	for _, name := range names {
+		if !declared[name] {
+			fresh++
+		}
+	}
+	if len(names) > 0 && fresh == 0 {
+		return "", false
+	}
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || fresh == len(names) {
+		return string(sourceOf(fset, source, decl)), true
+	}
+
+	// replacements of the byte ranges of the declared names, or of whole specs, in the source
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	replacements := []replacement{}
+	remove := func(node ast.Node) {
+		replacements = append(replacements, replacement{fset.Position(node.Pos()).Offset, fset.Position(node.End()).Offset, ""})
+	}
+	for _, spec := range gen.Specs {
+		switch s := spec.(type) {
+		case *ast.TypeSpec:
+			if declared[s.Name.Name] {
+				remove(s)
+			}
+		case *ast.ValueSpec:
+			all := true
+			for _, name := range s.Names {
+				if !declared[name.Name] {
+					all = false
+				}
+			}
+			if all && gen.Tok == token.VAR {
+				remove(s)
+				continue
+			}
+			for _, name := range s.Names {
+				if declared[name.Name] {
+					replacements = append(replacements, replacement{fset.Position(name.Pos()).Offset, fset.Position(name.End()).Offset, "_"})
+				}
+			}
+		}
+	}
+
+	src := sourceOf(fset, source, decl)
+	// the offset of the source within the file, which ends where the declaration does
+	offset := fset.Position(decl.End()).Offset - len(src)
+	var b strings.Builder
+	last := 0
+	for _, r := range replacements {
+		b.Write(src[last : r.start-offset])
+		b.WriteString(r.text)
+		last = r.end - offset
+	}
+	b.Write(src[last:])
+	return b.String(), true
+}
+
+// receiverName returns the name of a receiver type expression.
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	}
+	return ""
+}
+
+func isImportDecl(decl ast.Decl) bool {
+	gen, ok := decl.(*ast.GenDecl)
+	return ok && gen.Tok == token.IMPORT
+}
+
+// sourceOf returns the source text of the given declaration, including its doc comment.
+func sourceOf(fset *token.FileSet, source []byte, decl ast.Decl) []byte {
+	start := decl.Pos()
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	}
+	return source[fset.Position(start).Offset:fset.Position(decl.End()).Offset]
+}
+
+// mergeImports returns the union of the given import specs.
+func mergeImports(existing, generated []*ast.ImportSpec) []string {
+	seen := map[string]bool{}
+	specs := []string{}
+	all := append(append([]*ast.ImportSpec{}, existing...), generated...)
+	for _, spec := range all {
+		line := spec.Path.Value
+		if spec.Name != nil {
+			line = spec.Name.Name + " " + line
+		}
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		specs = append(specs, line)
+	}
+	return specs
+}
+
+// importDecl returns an import declaration for the given specs.
+func importDecl(specs []string) string {
+	switch len(specs) {
+	case 0:
+		return ""
+	case 1:
+		return "import " + specs[0]
+	}
+	return "import (\n\t" + strings.Join(specs, "\n\t") + "\n)"
+}
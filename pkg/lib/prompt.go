@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// PromptTemplates are the templates used to build the messages sent to the model.
+// Both are executed with a types.TestCodePrompt.
+type PromptTemplates struct {
+	System *template.Template
+	Prompt *template.Template
+}
+
+// sampleTestCodePrompt is used to validate templates before they are used.
+var sampleTestCodePrompt = types.TestCodePrompt{
+	TargetFunction:   "func Max[T Ordered](a, b T) T {\n\tif a > b {\n\t\treturn a\n\t}\n\treturn b\n}",
+	TypeParameters:   "[T Ordered]",
+	Constraints:      []string{"type Ordered interface {\n\t~int | ~float64 | ~string\n}"},
+	CalledFunctions:  []string{"func Sum(values ...int) int"},
+	PackageName:      "sample",
+	ExistingTests:    "func TestSum(t *testing.T) {}",
+	StyleExamples:    []string{"func TestSub(t *testing.T) {}"},
+	TestHelpers:      []string{"func newTestServer(t *testing.T) *Server"},
+	Fixtures:         []string{"testdata/input.json"},
+	TestMain:         "func TestMain(m *testing.M) {\n\tos.Exit(m.Run())\n}",
+	Style:            "table-driven",
+	AssertionLibrary: "testing",
+	Instructions:     "Keep the tests short.",
+}
+
+// LoadPromptTemplates loads the templates used to build prompts.
+// The system prompt is taken from systemPath, or else from the inline systemPrompt template;
+// the user prompt is taken from promptPath. The embedded defaults are used for any template
+// which is not given. Each template is validated by executing it against sample data.
+func LoadPromptTemplates(promptPath, systemPath, systemPrompt string) (PromptTemplates, error) {
+	var err error
+	templates := PromptTemplates{}
+
+	switch {
+	case systemPath != "":
+		templates.System, err = parseTemplateFile("system", systemPath)
+	case systemPrompt != "":
+		templates.System, err = parseTemplate("system", systemPrompt)
+	default:
+		templates.System, err = template.New("system.tmpl").ParseFS(defaultTemplates, "templates/system.tmpl")
+	}
+	if err != nil {
+		return PromptTemplates{}, fmt.Errorf("invalid system prompt template: %w", err)
+	}
+
+	if promptPath != "" {
+		templates.Prompt, err = parseTemplateFile("prompt", promptPath)
+	} else {
+		templates.Prompt, err = template.New("prompt.tmpl").ParseFS(defaultTemplates, "templates/prompt.tmpl")
+	}
+	if err != nil {
+		return PromptTemplates{}, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return templates, nil
+}
+
+func parseTemplateFile(name string, path string) (*template.Template, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read template: %w", err)
+	}
+	return parseTemplate(name, string(contents))
+}
+
+// parseTemplate parses the template and checks that it can be executed with a TestCodePrompt,
+// so that references to unknown variables are caught before any work is done.
+func parseTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(ioutil.Discard, sampleTestCodePrompt); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// executeTemplate renders the template with the given parameters.
+func executeTemplate(tmpl *template.Template, params types.TestCodePrompt) (string, error) {
+	var output strings.Builder
+	err := tmpl.Execute(&output, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	return output.String(), nil
+}
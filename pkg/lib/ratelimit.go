@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the requests sent to the model using token buckets
+// for the number of requests and the number of tokens per minute.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+}
+
+// NewRateLimiter creates a rate limiter; a limit of zero disables it.
+func NewRateLimiter(requestsPerMinute int, tokensPerMinute int) *RateLimiter {
+	return &RateLimiter{
+		requests: newBucket(requestsPerMinute),
+		tokens:   newBucket(tokensPerMinute),
+	}
+}
+
+// Wait blocks until a request using the estimated number of tokens may be sent, or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		delay := l.requests.delay(now, 1)
+		if tokensDelay := l.tokens.delay(now, float64(tokens)); tokensDelay > delay {
+			delay = tokensDelay
+		}
+		if delay == 0 {
+			l.requests.take(1)
+			l.tokens.take(float64(tokens))
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Adjust corrects the tokens taken for a request once its actual usage is known.
+// A positive difference takes further tokens, a negative one returns them.
+func (l *RateLimiter) Adjust(difference int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.take(float64(difference))
+}
+
+// bucket is a token bucket which refills to its capacity over a minute.
+// A nil bucket is unlimited.
+type bucket struct {
+	capacity  float64
+	available float64
+	updated   time.Time
+}
+
+func newBucket(perMinute int) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{
+		capacity:  float64(perMinute),
+		available: float64(perMinute),
+		updated:   time.Now(),
+	}
+}
+
+// delay returns how long to wait until n tokens are available.
+// Requests for more than the capacity wait for a full bucket.
+func (b *bucket) delay(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.available += b.capacity * now.Sub(b.updated).Minutes()
+	if b.available > b.capacity {
+		b.available = b.capacity
+	}
+	b.updated = now
+	if n > b.capacity {
+		n = b.capacity
+	}
+	if b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.capacity * float64(time.Minute))
+}
+
+// take removes n tokens from the bucket, which may leave it in debt.
+func (b *bucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.available -= n
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bounds of the delay between retries of a failed request, before jitter.
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute
+)
+
+// retryTransport retries requests which failed with a rate limit, a server error, a timeout,
+// or a network error, waiting with jittered exponential backoff or as long as the server's
+// Retry-After header asks. Every attempt is limited by its own timeout until its response headers arrive,
+// after which the body, such as a streamed completion, is read for as long as the request's context allows.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	// timeout limits the wait for the response headers of a single attempt, or zero for no limit.
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		ctx, cancel := context.WithCancel(req.Context())
+		var deadline *time.Timer
+		if t.timeout > 0 {
+			deadline = time.AfterFunc(t.timeout, cancel)
+		}
+		res, err := t.base.RoundTrip(attemptReq.WithContext(ctx))
+		// the timeout no longer applies once the response has started
+		if deadline != nil && !deadline.Stop() && err != nil {
+			err = fmt.Errorf("no response within %s: %w", t.timeout, err)
+		}
+
+		delay, retry := t.retryDelay(req.Context(), res, err, attempt)
+		// a body which cannot be read again cannot be retried
+		if !retry || attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			// the attempt's context must live until the body is read
+			res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		cancel()
+
+		if t.logger != nil {
+			reason := ""
+			if err != nil {
+				reason = err.Error()
+			} else {
+				reason = res.Status
+			}
+			t.logger.Warn("retrying request to the model", "attempt", attempt+1, "delay", delay, "reason", reason)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay reports whether the outcome of an attempt should be retried, and how long to wait before doing so.
+func (t *retryTransport) retryDelay(ctx context.Context, res *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		// errors after the request was cancelled are final, anything else is a timeout or network error
+		return backoff(attempt), ctx.Err() == nil
+	}
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode < http.StatusInternalServerError {
+		return 0, false
+	}
+	if delay, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+		return delay, true
+	}
+	return backoff(attempt), true
+}
+
+// backoff returns the exponential delay for the attempt, with half of it randomized
+// so that concurrent requests do not retry in lockstep.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// cancelOnClose cancels the context of a request once its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
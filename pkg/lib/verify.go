@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// VerifyTests compiles the tests of the package in the given directory, then runs the named tests.
+// The test run is skipped when the package does not compile.
+func VerifyTests(packageDir string, testNames []string) types.Verification {
+	verification := types.Verification{
+		Compile: types.StatusSkipped,
+		Test:    types.StatusSkipped,
+	}
+
+	// running no tests still compiles the test binary
+	compile := exec.Command("go", "test", "-count=1", "-run", "^$", ".")
+	compile.Dir = packageDir
+	out, err := compile.CombinedOutput()
+	if err != nil {
+		verification.Compile = types.StatusFailed
+		verification.Output = string(out)
+		return verification
+	}
+	verification.Compile = types.StatusPassed
+	if len(testNames) == 0 {
+		return verification
+	}
+
+	pattern := fmt.Sprintf("^(%s)$", strings.Join(testNames, "|"))
+	test := exec.Command("go", "test", "-count=1", "-run", pattern, ".")
+	test.Dir = packageDir
+	out, err = test.CombinedOutput()
+	verification.Output = string(out)
+	if err != nil {
+		verification.Test = types.StatusFailed
+		return verification
+	}
+	verification.Test = types.StatusPassed
+	return verification
+}
+
+// CompileTestFile compiles the tests of the package in the given directory as if the test file had the given content,
+// without writing it. When the tests do not compile, the output of the compiler is returned along with an error.
+func CompileTestFile(packageDir string, testFilePath string, content []byte) (string, error) {
+	absPath, err := filepath.Abs(testFilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve test file: %w", err)
+	}
+	dir, err := ioutil.TempDir("", "go-create-test-")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the overlay replaces the test file for the build only
+	replacement := filepath.Join(dir, filepath.Base(testFilePath))
+	if err := ioutil.WriteFile(replacement, content, 0644); err != nil {
+		return "", fmt.Errorf("could not write test file: %w", err)
+	}
+	overlay, err := json.Marshal(map[string]map[string]string{"Replace": {absPath: replacement}})
+	if err != nil {
+		return "", fmt.Errorf("could not encode overlay: %w", err)
+	}
+	overlayPath := filepath.Join(dir, "overlay.json")
+	if err := ioutil.WriteFile(overlayPath, overlay, 0644); err != nil {
+		return "", fmt.Errorf("could not write overlay: %w", err)
+	}
+
+	compile := exec.Command("go", "test", "-count=1", "-run", "^$", "-overlay", overlayPath, ".")
+	compile.Dir = packageDir
+	out, err := compile.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// report errors at the test file rather than at its temporary replacement
+		output := string(out)
+		if absPackageDir, err := filepath.Abs(packageDir); err == nil {
+			if rel, err := filepath.Rel(absPackageDir, replacement); err == nil {
+				output = strings.ReplaceAll(output, rel, filepath.Base(testFilePath))
+			}
+		}
+		return strings.ReplaceAll(output, replacement, testFilePath), fmt.Errorf("the tests do not compile")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error compiling tests: %w", err)
+	}
+	return "", nil
+}
+
+// TestNames returns the names of the tests declared in the given test file.
+func TestNames(src []byte) ([]string, error) {
+	testFile, err := SplitTestFile(src)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, decl := range testFile.Decls {
+		if decl.IsFunc && strings.HasPrefix(decl.Name, "Test") && decl.Name != "TestMain" {
+			names = append(names, decl.Name)
+		}
+	}
+	return names, nil
+}
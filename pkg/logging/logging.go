@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Log formats supported by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New creates a logger writing records of at least the given level to w in the given format.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// IsTerminal reports whether stdout is a terminal.
+func IsTerminal() bool {
+	return IsTerminalFile(os.Stdout)
+}
+
+// IsTerminalFile reports whether the given file is a terminal.
+func IsTerminalFile(f *os.File) bool {
+	fd := f.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+// ShowProgress reports whether spinners and progress bars should be drawn:
+// only when stdout is a terminal and the logger is not quiet.
+func ShowProgress(logger *slog.Logger) bool {
+	return IsTerminal() && logger.Enabled(context.Background(), slog.LevelInfo)
+}
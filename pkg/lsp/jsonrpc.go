@@ -0,0 +1,123 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	// codeRequestCancelled and codeRequestFailed are defined by the Language Server Protocol.
+	codeRequestCancelled = -32800
+	codeRequestFailed    = -32803
+)
+
+// message is a JSON-RPC request, notification, or response. Notifications have no ID,
+// and responses have no method.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+// responseError is the error of a failed request.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// conn reads and writes JSON-RPC messages framed by a Content-Length header, as the Language Server Protocol does.
+// Messages may be written from several goroutines at once.
+type conn struct {
+	in  *textproto.Reader
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read returns the next message. A message which is not valid JSON is returned as an error
+// once its content has been consumed, so that reading can continue with the next one.
+func (c *conn) read() (*message, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, content); err != nil {
+		return nil, fmt.Errorf("could not read message: %w", err)
+	}
+	msg := &message{}
+	if err := json.Unmarshal(content, msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: fmt.Sprintf("could not decode message: %v", err)}
+	}
+	return msg, nil
+}
+
+// write sends the message with the JSON-RPC version set.
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return fmt.Errorf("could not write message: %w", err)
+	}
+	if _, err := c.out.Write(content); err != nil {
+		return fmt.Errorf("could not write message: %w", err)
+	}
+	return nil
+}
+
+// reply responds to the request with the given ID with either its result or an error.
+func (c *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+	msg := &message{ID: id}
+	if err != nil {
+		rpcErr, ok := err.(*responseError)
+		if !ok {
+			rpcErr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		msg.Error = rpcErr
+	} else {
+		// a null result must still be sent, since a response has either a result or an error
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		msg.Result = result
+	}
+	return c.write(msg)
+}
+
+// notify sends a notification to the client.
+func (c *conn) notify(method string, params interface{}) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("could not encode params: %w", err)
+	}
+	return c.write(&message{Method: method, Params: content})
+}
@@ -0,0 +1,208 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// The types below are the parts of the Language Server Protocol the server uses.
+// Positions are zero-indexed, and their characters count UTF-16 code units.
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// OptionalVersionedTextDocumentIdentifier identifies a document at a version, or as it is on disk when Version is nil.
+type OptionalVersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type InitializeParams struct {
+	Capabilities ClientCapabilities `json:"capabilities"`
+}
+
+type ClientCapabilities struct {
+	Workspace struct {
+		WorkspaceEdit struct {
+			DocumentChanges    bool     `json:"documentChanges"`
+			ResourceOperations []string `json:"resourceOperations"`
+		} `json:"workspaceEdit"`
+	} `json:"workspace"`
+	TextDocument struct {
+		CodeAction struct {
+			ResolveSupport *struct {
+				Properties []string `json:"properties"`
+			} `json:"resolveSupport"`
+		} `json:"codeAction"`
+	} `json:"textDocument"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo   ServerInfo         `json:"serverInfo"`
+}
+
+type ServerCapabilities struct {
+	// TextDocumentSync is the kind of document synchronization, where 1 sends the full text on every change.
+	TextDocumentSync   int               `json:"textDocumentSync"`
+	CodeActionProvider CodeActionOptions `json:"codeActionProvider"`
+}
+
+type CodeActionOptions struct {
+	CodeActionKinds []string `json:"codeActionKinds"`
+	ResolveProvider bool     `json:"resolveProvider"`
+}
+
+type ServerInfo struct {
+	Name string `json:"name"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		// Range is set for incremental changes, which the server does not ask for.
+		Range *Range `json:"range,omitempty"`
+		Text  string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      struct {
+		Only []string `json:"only,omitempty"`
+	} `json:"context"`
+}
+
+type CodeAction struct {
+	Title string         `json:"title"`
+	Kind  string         `json:"kind"`
+	Edit  *WorkspaceEdit `json:"edit,omitempty"`
+	// Data identifies the function to generate the test of when the action is resolved.
+	Data *codeActionData `json:"data,omitempty"`
+}
+
+// codeActionData is the data a code action is resolved from.
+type codeActionData struct {
+	URI string `json:"uri"`
+	// Receiver tells apart methods of the same name declared on different types.
+	Receiver string `json:"receiver,omitempty"`
+	Function string `json:"function"`
+}
+
+// WorkspaceEdit holds either DocumentChanges, when the client supports them, or Changes keyed by URI.
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []interface{}         `json:"documentChanges,omitempty"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                              `json:"edits"`
+}
+
+type CreateFile struct {
+	Kind    string             `json:"kind"`
+	URI     string             `json:"uri"`
+	Options *CreateFileOptions `json:"options,omitempty"`
+}
+
+type CreateFileOptions struct {
+	IgnoreIfExists bool `json:"ignoreIfExists"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type CancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+type LogMessageParams struct {
+	// Type is the severity of the message, where 1 is an error and 2 a warning.
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// uriToPath returns the path of the file with the given file URI.
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI %q: %w", uri, err)
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %q, only files are supported", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathToURI returns the file URI of the given absolute path.
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// byteColumn converts the character of a position, in UTF-16 code units, to a byte offset within its line.
+func byteColumn(text string, position Position) int {
+	lines := strings.Split(text, "\n")
+	if position.Line >= len(lines) {
+		return 0
+	}
+	line := lines[position.Line]
+	units := 0
+	for offset, r := range line {
+		if units >= position.Character {
+			return offset
+		}
+		units += utf16Len(r)
+	}
+	return len(line)
+}
+
+// endPosition returns the position just after the last character of the text.
+func endPosition(text string) Position {
+	lines := strings.Split(text, "\n")
+	units := 0
+	for _, r := range lines[len(lines)-1] {
+		units += utf16Len(r)
+	}
+	return Position{Line: len(lines) - 1, Character: units}
+}
+
+// utf16Len returns the number of UTF-16 code units encoding the rune.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
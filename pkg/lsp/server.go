@@ -0,0 +1,427 @@
+// Package lsp serves a code action generating tests to editors over the Language Server Protocol.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/robotsail/go-create-test/pkg/parse"
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// CodeActionKind is the kind of the code action which generates a test.
+const CodeActionKind = "source.generateTest"
+
+// serverName is the name the server reports to the client.
+const serverName = "go-create-test"
+
+// TestFile is the test file of a function with its generated tests merged in.
+type TestFile struct {
+	// Path is the absolute path of the test file.
+	Path string
+	// Exists is set when the test file exists already, with the content of Existing.
+	Exists   bool
+	Existing string
+	// Content is the test file with the generated tests merged in.
+	Content string
+}
+
+// GenerateFunc generates a test for the named function declared in the file at the given absolute path.
+// The receiver is the receiver type of a method, or empty for a function.
+type GenerateFunc func(ctx context.Context, file string, receiver string, function string) (TestFile, error)
+
+// Server offers a "Generate test for X" code action on the function and method declarations of Go files.
+// The action is resolved lazily into a WorkspaceEdit which creates or edits the test file, since generating
+// the test takes far longer than an editor waits for the list of code actions.
+type Server struct {
+	conn     *conn
+	logger   *slog.Logger
+	generate GenerateFunc
+
+	mu sync.Mutex
+	// documents are the texts of the documents open in the editor, by path.
+	documents map[string]string
+	// cancels cancel the requests in progress, by their ID.
+	cancels      map[string]context.CancelFunc
+	capabilities ClientCapabilities
+	shutdown     bool
+	requests     sync.WaitGroup
+}
+
+// NewServer creates a server reading messages from in and writing them to out.
+func NewServer(in io.Reader, out io.Writer, logger *slog.Logger, generate GenerateFunc) *Server {
+	return &Server{
+		conn:      newConn(in, out),
+		logger:    logger,
+		generate:  generate,
+		documents: map[string]string{},
+		cancels:   map[string]context.CancelFunc{},
+	}
+}
+
+// Run serves requests until the client sends the exit notification, the input ends, or the context is cancelled.
+// Requests which may take long, such as resolving a code action, are served concurrently and can be cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.requests.Wait()
+	}()
+
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			var rpcErr *responseError
+			if errors.As(err, &rpcErr) {
+				s.logger.Warn("skipping invalid message", "error", err)
+				if err := s.conn.reply(nil, nil, rpcErr); err != nil {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("error reading message: %w", err)
+		}
+		if msg.Method == "" {
+			// the server sends no requests, so there are no responses to expect
+			continue
+		}
+		if msg.ID == nil {
+			if msg.Method == "exit" {
+				if !s.isShutdown() {
+					return fmt.Errorf("exit without shutdown")
+				}
+				return nil
+			}
+			s.handleNotification(msg)
+			continue
+		}
+		if err := s.handleRequest(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) isShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// handleRequest responds to the request, in the background if it may take long.
+func (s *Server) handleRequest(ctx context.Context, msg *message) error {
+	s.logger.Debug("received request", "method", msg.Method)
+	switch msg.Method {
+	case "initialize":
+		result, err := s.initialize(msg.Params)
+		return s.conn.reply(msg.ID, result, err)
+	case "shutdown":
+		s.mu.Lock()
+		s.shutdown = true
+		s.mu.Unlock()
+		return s.conn.reply(msg.ID, nil, nil)
+	case "textDocument/codeAction", "codeAction/resolve":
+	default:
+		return s.conn.reply(msg.ID, nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q is not supported", msg.Method)})
+	}
+	if s.isShutdown() {
+		return s.conn.reply(msg.ID, nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"})
+	}
+
+	id := string(*msg.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancels[id] = cancel
+	s.mu.Unlock()
+	s.requests.Add(1)
+	go func() {
+		defer s.requests.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.cancels, id)
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		var result interface{}
+		var err error
+		switch msg.Method {
+		case "textDocument/codeAction":
+			params := CodeActionParams{}
+			if err = decodeParams(msg.Params, &params); err == nil {
+				result, err = s.codeActions(params)
+			}
+		case "codeAction/resolve":
+			action := CodeAction{}
+			if err = decodeParams(msg.Params, &action); err == nil {
+				result, err = s.resolveCodeAction(ctx, action)
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			err = &responseError{Code: codeRequestCancelled, Message: "the request was cancelled"}
+		}
+		if err != nil {
+			s.logger.Error("request failed", "method", msg.Method, "error", err)
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			s.logger.Error("could not respond", "method", msg.Method, "error", err)
+		}
+	}()
+	return nil
+}
+
+// handleNotification updates the open documents, or cancels a request. Other notifications are ignored.
+func (s *Server) handleNotification(msg *message) {
+	s.logger.Debug("received notification", "method", msg.Method)
+	var err error
+	switch msg.Method {
+	case "textDocument/didOpen":
+		params := DidOpenTextDocumentParams{}
+		if err = decodeParams(msg.Params, &params); err == nil {
+			err = s.setDocument(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		params := DidChangeTextDocumentParams{}
+		if err = decodeParams(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			// the full text is synchronized, so the last change holds the whole document
+			err = s.setDocument(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		params := DidCloseTextDocumentParams{}
+		if err = decodeParams(msg.Params, &params); err == nil {
+			var path string
+			if path, err = uriToPath(params.TextDocument.URI); err == nil {
+				s.mu.Lock()
+				delete(s.documents, path)
+				s.mu.Unlock()
+			}
+		}
+	case "$/cancelRequest":
+		params := CancelParams{}
+		if err = decodeParams(msg.Params, &params); err == nil {
+			s.mu.Lock()
+			if cancel, ok := s.cancels[string(params.ID)]; ok {
+				cancel()
+			}
+			s.mu.Unlock()
+		}
+	}
+	if err != nil {
+		s.logger.Warn("could not handle notification", "method", msg.Method, "error", err)
+	}
+}
+
+func (s *Server) initialize(raw json.RawMessage) (InitializeResult, error) {
+	params := InitializeParams{}
+	if err := decodeParams(raw, &params); err != nil {
+		return InitializeResult{}, err
+	}
+	s.mu.Lock()
+	s.capabilities = params.Capabilities
+	s.mu.Unlock()
+	if !s.resolvesEdits() {
+		message := "the editor cannot resolve the edits of code actions, so no tests can be generated"
+		s.logger.Warn(message)
+		if err := s.conn.notify("window/logMessage", LogMessageParams{Type: 2, Message: message}); err != nil {
+			return InitializeResult{}, err
+		}
+	}
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: 1,
+			CodeActionProvider: CodeActionOptions{
+				CodeActionKinds: []string{CodeActionKind},
+				ResolveProvider: true,
+			},
+		},
+		ServerInfo: ServerInfo{Name: serverName},
+	}, nil
+}
+
+// resolvesEdits reports whether the client resolves the edits of code actions with codeAction/resolve.
+func (s *Server) resolvesEdits() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	support := s.capabilities.TextDocument.CodeAction.ResolveSupport
+	if support == nil {
+		return false
+	}
+	for _, property := range support.Properties {
+		if property == "edit" {
+			return true
+		}
+	}
+	return false
+}
+
+// codeActions offers to generate a test for the function or method whose declaration encloses the start of the range.
+func (s *Server) codeActions(params CodeActionParams) ([]CodeAction, error) {
+	actions := []CodeAction{}
+	if !s.resolvesEdits() || !wantsKind(params.Context.Only) {
+		return actions, nil
+	}
+	path, err := uriToPath(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+		return actions, nil
+	}
+	text, err := s.document(path)
+	if err != nil {
+		return nil, err
+	}
+	functions, err := parse.ListFunctions([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("could not list functions: %w", err)
+	}
+	start := params.Range.Start
+	function, ok := parse.FunctionAt(functions, types.Position{Line: start.Line + 1, Column: byteColumn(text, start) + 1})
+	if !ok {
+		return actions, nil
+	}
+	return append(actions, CodeAction{
+		Title: fmt.Sprintf("Generate test for %s", parse.QualifiedName(function.Receiver, function.Name)),
+		Kind:  CodeActionKind,
+		Data:  &codeActionData{URI: params.TextDocument.URI, Receiver: function.Receiver, Function: function.Name},
+	}), nil
+}
+
+// wantsKind reports whether the client asks for code actions of the server's kind,
+// which it does when it asks for no particular kinds.
+func wantsKind(only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, kind := range only {
+		if kind == CodeActionKind || strings.HasPrefix(CodeActionKind, kind+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveCodeAction generates the test of the code action's function, and sets the edit writing it to the test file.
+func (s *Server) resolveCodeAction(ctx context.Context, action CodeAction) (CodeAction, error) {
+	if action.Data == nil {
+		return CodeAction{}, &responseError{Code: codeInvalidParams, Message: "the code action has no data to resolve it from"}
+	}
+	path, err := uriToPath(action.Data.URI)
+	if err != nil {
+		return CodeAction{}, err
+	}
+	// the test is generated from the source file on disk, which may not have the function of the action
+	if s.unsaved(path) {
+		return CodeAction{}, fmt.Errorf("%s has unsaved changes, save it before generating a test", filepath.Base(path))
+	}
+	s.logger.Info("generating test", "file", path, "function", parse.QualifiedName(action.Data.Receiver, action.Data.Function))
+	testFile, err := s.generate(ctx, path, action.Data.Receiver, action.Data.Function)
+	if err != nil {
+		return CodeAction{}, err
+	}
+	// the edit is computed against the test file on disk, which would undo any unsaved changes to it
+	s.mu.Lock()
+	text, open := s.documents[testFile.Path]
+	s.mu.Unlock()
+	if open && text != testFile.Existing {
+		return CodeAction{}, fmt.Errorf("%s has unsaved changes, save it before generating a test", filepath.Base(testFile.Path))
+	}
+	action.Edit, err = s.testFileEdit(testFile)
+	if err != nil {
+		return CodeAction{}, err
+	}
+	return action, nil
+}
+
+// testFileEdit returns the edit which creates the test file, or replaces its content.
+func (s *Server) testFileEdit(testFile TestFile) (*WorkspaceEdit, error) {
+	s.mu.Lock()
+	capabilities := s.capabilities.Workspace.WorkspaceEdit
+	s.mu.Unlock()
+
+	uri := pathToURI(testFile.Path)
+	edit := TextEdit{
+		Range:   Range{End: endPosition(testFile.Existing)},
+		NewText: testFile.Content,
+	}
+	if testFile.Exists && !capabilities.DocumentChanges {
+		return &WorkspaceEdit{Changes: map[string][]TextEdit{uri: {edit}}}, nil
+	}
+	if testFile.Exists {
+		return &WorkspaceEdit{DocumentChanges: []interface{}{
+			TextDocumentEdit{TextDocument: OptionalVersionedTextDocumentIdentifier{URI: uri}, Edits: []TextEdit{edit}},
+		}}, nil
+	}
+
+	creates := false
+	for _, operation := range capabilities.ResourceOperations {
+		creates = creates || operation == "create"
+	}
+	if !capabilities.DocumentChanges || !creates {
+		return nil, fmt.Errorf("the editor cannot create files, create %s to generate a test", filepath.Base(testFile.Path))
+	}
+	return &WorkspaceEdit{DocumentChanges: []interface{}{
+		CreateFile{Kind: "create", URI: uri, Options: &CreateFileOptions{IgnoreIfExists: true}},
+		TextDocumentEdit{TextDocument: OptionalVersionedTextDocumentIdentifier{URI: uri}, Edits: []TextEdit{edit}},
+	}}, nil
+}
+
+// setDocument records the text of an open document.
+func (s *Server) setDocument(uri string, text string) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[path] = text
+	return nil
+}
+
+// unsaved reports whether the document is open in the editor with a text which differs from the file on disk.
+func (s *Server) unsaved(path string) bool {
+	s.mu.Lock()
+	text, open := s.documents[path]
+	s.mu.Unlock()
+	if !open {
+		return false
+	}
+	code, err := ioutil.ReadFile(path)
+	return err != nil || string(code) != text
+}
+
+// document returns the text of the document open in the editor, or of the file on disk when it is not open.
+func (s *Server) document(path string) (string, error) {
+	s.mu.Lock()
+	text, ok := s.documents[path]
+	s.mu.Unlock()
+	if ok {
+		return text, nil
+	}
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read file: %w", err)
+	}
+	return string(code), nil
+}
+
+// decodeParams decodes the params of a message.
+func decodeParams(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+)
+
+// GetDeclarationAt returns the source of the declaration of the symbol at the given one-indexed position,
+// such as a variable or struct field holding a function value, including its doc comment.
+// A field is returned as the declaration of its struct, and a specification within a group
+// as a declaration of its own.
+func GetDeclarationAt(code []byte, position sitter.Point) (string, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return "", fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	point := sitter.Point{Row: position.Row - 1, Column: position.Column - 1}
+	for node := tree.RootNode().NamedDescendantForPointRange(point, point); node != nil; node = node.Parent() {
+		// fields are found through the type specification of their struct
+		switch node.Type() {
+		case "function_declaration", "method_declaration":
+			return withComments(node, code), nil
+		case "var_spec":
+			return specSource(node, "var", code), nil
+		case "const_spec":
+			return specSource(node, "const", code), nil
+		case "type_spec", "type_alias":
+			return specSource(node, "type", code), nil
+		case "short_var_declaration":
+			return withComments(node, code), nil
+		}
+	}
+	return "", fmt.Errorf("no declaration found at %d:%d", position.Row, position.Column)
+}
+
+// specSource returns the source of a type, var, or const specification including its doc comment.
+// The whole declaration is returned when it declares a single specification or is a group of constants using iota,
+// and otherwise the specification is returned as a declaration of its own, e.g. 'type Number interface{...}'.
+func specSource(spec *sitter.Node, keyword string, code []byte) string {
+	declaration := spec.Parent()
+	// the values of constants in a group using iota depend on their position within it
+	if single(declaration) || (keyword == "const" && usesIota(declaration)) {
+		return withComments(declaration, code)
+	}
+	source := withComments(spec, code)
+	body := spec.Content(code)
+	comments := strings.TrimRight(strings.TrimSuffix(source, body), " \t")
+	// specifications within a group are indented by one level
+	return strings.ReplaceAll(comments+keyword+" "+body, "\n\t", "\n")
+}
+
+// usesIota reports whether iota appears within the given declaration.
+func usesIota(declaration *sitter.Node) bool {
+	found := false
+	iter := sitter.NewIterator(declaration, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() == "iota" {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// GetSummaryAt returns the first sentence of the doc comment and the signature of the function or method
+// declared at the given one-indexed position, noting the import path it is declared in.
+// Types, variables, constants, and struct fields are summarized by the first line of their declaration,
+// e.g. 'type Request struct { ... }', and constants in a group using iota are returned in full,
+// since their values depend on their position within it.
+func GetSummaryAt(code []byte, position sitter.Point, importPath string) (string, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return "", fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	point := sitter.Point{Row: position.Row - 1, Column: position.Column - 1}
+	var doc, declaration string
+	for node := tree.RootNode().NamedDescendantForPointRange(point, point); node != nil && declaration == ""; node = node.Parent() {
+		switch node.Type() {
+		case "function_declaration", "method_declaration":
+			doc = strings.TrimSuffix(withComments(node, code), node.Content(code))
+			declaration = signature(node, code)
+		case "field_declaration":
+			doc = strings.TrimSuffix(withComments(node, code), node.Content(code))
+			declaration = firstLine(node.Content(code))
+			if name := structName(node, code); name != "" {
+				declaration = fmt.Sprintf("%s // field of %s", declaration, name)
+			}
+		case "var_spec", "const_spec", "type_spec", "type_alias":
+			keyword := map[string]string{"var_spec": "var", "const_spec": "const"}[node.Type()]
+			if keyword == "" {
+				keyword = "type"
+			}
+			if keyword == "const" && usesIota(node.Parent()) {
+				doc, declaration = "", specSource(node, keyword, code)
+				break
+			}
+			// the doc comment of a single specification is the declaration's
+			commented := node
+			if single(node.Parent()) {
+				commented = node.Parent()
+			}
+			doc = strings.TrimSuffix(withComments(commented, code), commented.Content(code))
+			declaration = keyword + " " + firstLine(node.Content(code))
+		}
+	}
+	if declaration == "" {
+		return "", fmt.Errorf("no declaration found at %d:%d", position.Row, position.Column)
+	}
+
+	lines := []string{}
+	if sentence := firstSentence(doc); sentence != "" {
+		lines = append(lines, "// "+sentence)
+	}
+	if importPath != "" {
+		lines = append(lines, fmt.Sprintf("// imported from %q", importPath))
+	}
+	return strings.Join(append(lines, declaration), "\n"), nil
+}
+
+// single reports whether the given declaration declares a single specification.
+func single(declaration *sitter.Node) bool {
+	specs := 0
+	for i := 0; i < int(declaration.NamedChildCount()); i++ {
+		if child := declaration.NamedChild(i); child.Type() != "comment" {
+			specs++
+		}
+	}
+	return specs == 1
+}
+
+// firstLine returns the first line of a declaration, eliding the rest of it up to its last line,
+// e.g. 'Request struct { ... }'.
+func firstLine(content string) string {
+	lines := strings.Split(content, "\n")
+	if len(lines) == 1 {
+		return content
+	}
+	return strings.TrimSpace(lines[0]) + " ... " + strings.TrimSpace(lines[len(lines)-1])
+}
+
+// structName returns the name of the type declaring the struct the given field belongs to, if any.
+func structName(field *sitter.Node, code []byte) string {
+	for node := field.Parent(); node != nil; node = node.Parent() {
+		if node.Type() == "type_spec" {
+			if name := node.ChildByFieldName("name"); name != nil {
+				return name.Content(code)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// firstSentence returns the first sentence of a doc comment, without its comment markers.
+// A sentence ends at a full stop followed by a word which does not start in lower case,
+// unless the full stop ends an abbreviation such as 'e.g.'.
+func firstSentence(doc string) string {
+	words := []string{}
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		if line == "" {
+			if len(words) > 0 {
+				// the first paragraph ends without a full stop
+				break
+			}
+			continue
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	for i := 0; i+1 < len(words); i++ {
+		next, _ := utf8.DecodeRuneInString(words[i+1])
+		if strings.HasSuffix(words[i], ".") && !abbreviation(words[i]) && !unicode.IsLower(next) {
+			return strings.Join(words[:i+1], " ")
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// abbreviation reports whether the word is an abbreviation made of single letters, such as 'e.g.' or 'i.e.'.
+func abbreviation(word string) bool {
+	parts := strings.Split(strings.TrimSuffix(word, "."), ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) != 1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// branchNodeTypes are the node types which add a path through a function
+// when computing its cyclomatic complexity.
+var branchNodeTypes = map[string]bool{
+	"if_statement":       true,
+	"for_statement":      true,
+	"expression_case":    true,
+	"type_case":          true,
+	"communication_case": true,
+}
+
+// ListFunctions returns every top-level function and method declared in the given file.
+func ListFunctions(code []byte) ([]types.FunctionInfo, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	root := tree.RootNode()
+	functions := []types.FunctionInfo{}
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		node := root.NamedChild(i)
+		if node.Type() != "function_declaration" && node.Type() != "method_declaration" {
+			continue
+		}
+		name := node.ChildByFieldName("name")
+		if name == nil {
+			continue
+		}
+		functions = append(functions, types.FunctionInfo{
+			Name:     name.Content(code),
+			Receiver: receiverType(node, code),
+			Range: types.Range{
+				Start: node.StartPoint(),
+				End:   node.EndPoint(),
+			},
+			Complexity: cyclomaticComplexity(node, code),
+		})
+	}
+	return functions, nil
+}
+
+// QualifiedName returns the name of a method qualified by the name of its receiver type, e.g. 'Server.Start',
+// or the name itself for a function.
+func QualifiedName(receiver string, name string) string {
+	if receiver == "" {
+		return name
+	}
+	return receiver + "." + name
+}
+
+// SplitQualifiedName splits a name which may be qualified by a receiver type into the receiver type and the name.
+func SplitQualifiedName(name string) (receiver string, function string) {
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		return name[:idx], name[idx+1:]
+	}
+	return "", name
+}
+
+// receiverType returns the name of the receiver type of a method declaration,
+// without any pointer indirection. e.g. 'func (s *Server) Start()' returns 'Server'.
+func receiverType(t *sitter.Node, source []byte) string {
+	receiver := t.ChildByFieldName("receiver")
+	if receiver == nil {
+		return ""
+	}
+	for i := 0; i < int(receiver.NamedChildCount()); i++ {
+		param := receiver.NamedChild(i)
+		if param.Type() != "parameter_declaration" {
+			continue
+		}
+		typeNode := param.ChildByFieldName("type")
+		if typeNode == nil {
+			continue
+		}
+		typeName := strings.TrimLeft(typeNode.Content(source), "*")
+		// drop any type arguments, e.g. 'List[T]' becomes 'List'
+		if idx := strings.Index(typeName, "["); idx != -1 {
+			typeName = typeName[:idx]
+		}
+		return typeName
+	}
+	return ""
+}
+
+// cyclomaticComplexity approximates the cyclomatic complexity of the given declaration
+// by counting its branching statements and boolean operators.
+func cyclomaticComplexity(t *sitter.Node, source []byte) int {
+	complexity := 1
+	iter := sitter.NewIterator(t, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if branchNodeTypes[node.Type()] {
+			complexity++
+			return nil
+		}
+		if node.Type() == "binary_expression" {
+			operator := node.ChildByFieldName("operator")
+			if operator != nil && (operator.Type() == "&&" || operator.Type() == "||") {
+				complexity++
+			}
+		}
+		return nil
+	})
+	return complexity
+}
+
+// ChangedFunctions returns the top-level functions and methods of the file which overlap any of the changed lines.
+func ChangedFunctions(code []byte, changes []types.LineRange) ([]types.FunctionInfo, error) {
+	functions, err := ListFunctions(code)
+	if err != nil {
+		return nil, err
+	}
+	changed := []types.FunctionInfo{}
+	for _, function := range functions {
+		// tree-sitter rows are zero-indexed
+		start, end := int(function.Range.Start.Row)+1, int(function.Range.End.Row)+1
+		for _, lines := range changes {
+			if lines.Start <= end && lines.End >= start {
+				changed = append(changed, function)
+				break
+			}
+		}
+	}
+	return changed, nil
+}
+
+// FunctionAt returns the function or method whose declaration encloses the given position.
+func FunctionAt(functions []types.FunctionInfo, position types.Position) (types.FunctionInfo, bool) {
+	for _, function := range functions {
+		// tree-sitter rows and columns are zero-indexed
+		start, end := function.Range.Start, function.Range.End
+		line := uint32(position.Line - 1)
+		if line < start.Row || line > end.Row {
+			continue
+		}
+		if position.Column > 0 {
+			column := uint32(position.Column - 1)
+			if (line == start.Row && column < start.Column) || (line == end.Row && column >= end.Column) {
+				continue
+			}
+		}
+		return function, true
+	}
+	return types.FunctionInfo{}, false
+}
+
+// NearbyFunctions returns up to limit functions and methods, ordered by the distance of their declaration
+// from the given one-indexed line.
+func NearbyFunctions(functions []types.FunctionInfo, line int, limit int) []types.FunctionInfo {
+	distance := func(function types.FunctionInfo) int {
+		start, end := int(function.Range.Start.Row)+1, int(function.Range.End.Row)+1
+		switch {
+		case line < start:
+			return start - line
+		case line > end:
+			return line - end
+		}
+		return 0
+	}
+	nearby := append([]types.FunctionInfo{}, functions...)
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return distance(nearby[i]) < distance(nearby[j])
+	})
+	if len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby
+}
@@ -0,0 +1,209 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// predeclaredTypes are the predeclared identifiers which may appear within a constraint,
+// and have no definition to look up.
+var predeclaredTypes = map[string]bool{
+	"any": true, "comparable": true, "error": true, "bool": true, "string": true, "byte": true, "rune": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"float32": true, "float64": true, "complex64": true, "complex128": true,
+}
+
+// Generics describes the type parameters of a generic function, or of the receiver type of a method on a generic type.
+type Generics struct {
+	// TypeParameters is the type parameter list, e.g. '[K comparable, V Number]', or empty if there is none.
+	TypeParameters string
+	// Constraints are the definitions of the named constraints of the type parameters, e.g. 'Number'.
+	Constraints []types.Definition
+}
+
+// IsGeneric reports whether the function has type parameters.
+func (g Generics) IsGeneric() bool {
+	return g.TypeParameters != ""
+}
+
+// GetGenerics returns the type parameters of the given function and the definitions of their constraints.
+// The name of a method may be qualified by its receiver type, e.g. 'Server.Start'.
+// The type parameters of a method are those of its receiver type, which is searched for in the files of the package.
+func GetGenerics(logger *slog.Logger, filepath string, functionName string, code []byte) (Generics, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return Generics{}, fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return Generics{}, fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	declaration := findDeclaration(functionName, tree.RootNode(), code)
+	if declaration == nil {
+		return Generics{}, fmt.Errorf("could not find function %q", functionName)
+	}
+	if declaration.Type() == "method_declaration" {
+		return receiverGenerics(logger, filepath, receiverType(declaration, code))
+	}
+	return typeParameters(logger, filepath, code, declaration.ChildByFieldName("type_parameters"))
+}
+
+// findDeclaration returns the top-level function or method declaration with the given name.
+// A name qualified by a receiver type, e.g. 'Server.Start', only matches the method of that type,
+// while an unqualified name prefers a function over the methods of the same name.
+func findDeclaration(name string, root *sitter.Node, source []byte) *sitter.Node {
+	receiver, name := SplitQualifiedName(name)
+	var method *sitter.Node
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		node := root.NamedChild(i)
+		if node.Type() != "function_declaration" && node.Type() != "method_declaration" {
+			continue
+		}
+		if nameNode := node.ChildByFieldName("name"); nameNode == nil || nameNode.Content(source) != name {
+			continue
+		}
+		switch {
+		case receiver != "":
+			if node.Type() == "method_declaration" && receiverType(node, source) == receiver {
+				return node
+			}
+		case node.Type() == "function_declaration":
+			return node
+		case method == nil:
+			method = node
+		}
+	}
+	return method
+}
+
+// findTypeSpec returns the top-level type specification with the given name, which may be part of a group.
+func findTypeSpec(name string, root *sitter.Node, source []byte) *sitter.Node {
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		declaration := root.NamedChild(i)
+		if declaration.Type() != "type_declaration" {
+			continue
+		}
+		for j := 0; j < int(declaration.NamedChildCount()); j++ {
+			spec := declaration.NamedChild(j)
+			if spec.Type() != "type_spec" && spec.Type() != "type_alias" {
+				continue
+			}
+			if nameNode := spec.ChildByFieldName("name"); nameNode != nil && nameNode.Content(source) == name {
+				return spec
+			}
+		}
+	}
+	return nil
+}
+
+// receiverGenerics returns the type parameters of the named type, declared in the same package as the given file.
+func receiverGenerics(logger *slog.Logger, file string, typeName string) (Generics, error) {
+	// the declaring file is the most likely place for the type, so it is searched first
+	candidates, err := PackageFiles(file)
+	if err != nil {
+		return Generics{}, err
+	}
+
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+	for _, candidate := range candidates {
+		code, err := ioutil.ReadFile(candidate)
+		if err != nil {
+			return Generics{}, fmt.Errorf("could not read file: %w", err)
+		}
+		tree, err := parser.ParseCtx(context.Background(), nil, code)
+		if tree == nil || err != nil {
+			logger.Debug("could not parse file, skipping", "file", candidate, "error", err)
+			continue
+		}
+		spec := findTypeSpec(typeName, tree.RootNode(), code)
+		if spec == nil {
+			tree.Close()
+			continue
+		}
+		generics, err := typeParameters(logger, candidate, code, spec.ChildByFieldName("type_parameters"))
+		tree.Close()
+		return generics, err
+	}
+	logger.Debug("could not find receiver type", "type", typeName)
+	return Generics{}, nil
+}
+
+// typeParameters describes the given type parameter list, resolving the definitions of its named constraints.
+func typeParameters(logger *slog.Logger, file string, code []byte, list *sitter.Node) (Generics, error) {
+	if list == nil {
+		return Generics{}, nil
+	}
+	generics := Generics{TypeParameters: list.Content(code)}
+
+	// type parameters may constrain each other, e.g. '[S ~[]E, E any]'
+	names := map[string]bool{}
+	for i := 0; i < int(list.NamedChildCount()); i++ {
+		param := list.NamedChild(i)
+		for j := 0; j < int(param.NamedChildCount()); j++ {
+			if child := param.NamedChild(j); child.Type() == "identifier" {
+				names[child.Content(code)] = true
+			}
+		}
+	}
+
+	refs := map[string]FunctionCallRef{}
+	for i := 0; i < int(list.NamedChildCount()); i++ {
+		constraint := list.NamedChild(i).ChildByFieldName("type")
+		if constraint == nil {
+			continue
+		}
+		iter := sitter.NewIterator(constraint, sitter.DFSMode)
+		_ = iter.ForEach(func(node *sitter.Node) error {
+			var ref *sitter.Node
+			switch node.Type() {
+			case "qualified_type":
+				// e.g. 'constraints.Ordered', which is resolved at 'Ordered'
+				ref = node.ChildByFieldName("name")
+			case "type_identifier":
+				if parent := node.Parent(); parent == nil || parent.Type() != "qualified_type" {
+					ref = node
+				}
+			}
+			if ref == nil {
+				return nil
+			}
+			name := node.Content(code)
+			if predeclaredTypes[name] || names[name] {
+				return nil
+			}
+			if _, ok := refs[name]; !ok {
+				refs[name] = FunctionCallRef{Name: name, Ref: ref}
+			}
+			return nil
+		})
+	}
+	if len(refs) == 0 {
+		return generics, nil
+	}
+
+	locations, err := findDefinitions(logger, file, refs, code, nil)
+	if err != nil {
+		return Generics{}, fmt.Errorf("error finding constraint definitions: %w", err)
+	}
+	generics.Constraints, err = readFunctionDefinitions(logger, file, locations, nil)
+	if err != nil {
+		return Generics{}, fmt.Errorf("error reading constraint definitions: %w", err)
+	}
+	return generics, nil
+}
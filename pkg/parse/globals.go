@@ -0,0 +1,189 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// packageGlobals describes the package-level variables and constants of a package, and its init functions.
+type packageGlobals struct {
+	// names are the names of the package-level variables and constants.
+	names map[string]bool
+	// inits are the init functions of the package, in the order of their files.
+	inits []initFunction
+}
+
+// initFunction is an init function, along with the identifiers it refers to.
+type initFunction struct {
+	filepath string
+	line     int
+	source   string
+	refs     map[string]bool
+}
+
+// PackageFiles returns the non-test Go files in the package of the given file, starting with the file itself.
+// Files excluded by their build constraints or GOOS and GOARCH suffixes are left out, as the go command does,
+// so that alternative declarations such as 'config_windows.go' are not mistaken for those of the file.
+func PackageFiles(file string) ([]string, error) {
+	dir := filepath.Dir(file)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list package files: %w", err)
+	}
+	candidates := []string{file}
+	for _, entry := range entries {
+		name := entry.Name()
+		candidate := filepath.Join(dir, name)
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || filepath.Clean(candidate) == filepath.Clean(file) {
+			continue
+		}
+		match, err := build.Default.MatchFile(dir, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not read build constraints of %q: %w", candidate, err)
+		}
+		if match {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+// readPackageGlobals collects the package-level variables, constants, and init functions
+// declared in the files of the package of the given file which are built for the current platform.
+// Files which cannot be parsed are skipped.
+func readPackageGlobals(logger *slog.Logger, file string) (packageGlobals, error) {
+	globals := packageGlobals{names: map[string]bool{}}
+	files, err := PackageFiles(file)
+	if err != nil {
+		return globals, err
+	}
+
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+	for _, candidate := range files {
+		code, err := ioutil.ReadFile(candidate)
+		if err != nil {
+			return globals, fmt.Errorf("could not read file: %w", err)
+		}
+		tree, err := parser.ParseCtx(context.Background(), nil, code)
+		if tree == nil || err != nil {
+			logger.Debug("could not parse file, skipping", "file", candidate, "error", err)
+			continue
+		}
+		root := tree.RootNode()
+		for i := 0; i < int(root.NamedChildCount()); i++ {
+			node := root.NamedChild(i)
+			switch node.Type() {
+			case "var_declaration", "const_declaration":
+				for _, name := range declaredNames(node, code) {
+					globals.names[name] = true
+				}
+			case "function_declaration":
+				if name := node.ChildByFieldName("name"); name == nil || name.Content(code) != "init" {
+					continue
+				}
+				absPath, err := filepath.Abs(candidate)
+				if err != nil {
+					absPath = candidate
+				}
+				globals.inits = append(globals.inits, initFunction{
+					filepath: absPath,
+					line:     int(node.StartPoint().Row) + 1,
+					source:   withComments(node, code),
+					refs:     identifiers(node, code),
+				})
+			}
+		}
+		tree.Close()
+	}
+	return globals, nil
+}
+
+// declaredNames returns the names declared by a var or const declaration, including those within a group.
+func declaredNames(declaration *sitter.Node, source []byte) []string {
+	names := []string{}
+	iter := sitter.NewIterator(declaration, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() != "var_spec" && node.Type() != "const_spec" {
+			return nil
+		}
+		// the names precede the type and the values of the specification
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			child := node.NamedChild(i)
+			if child.Type() != "identifier" {
+				break
+			}
+			if name := child.Content(source); name != "_" {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	return names
+}
+
+// identifiers returns the names of the identifiers within the given node.
+func identifiers(t *sitter.Node, source []byte) map[string]bool {
+	names := map[string]bool{}
+	iter := sitter.NewIterator(t, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() == "identifier" {
+			names[node.Content(source)] = true
+		}
+		return nil
+	})
+	return names
+}
+
+// findGlobalReferences returns every reference within the target to the given package-level names, keyed by
+// call site. A reference may be to a local variable shadowing the name, which is told apart once it is resolved,
+// so the references are not deduplicated by name: the first one may be the shadowing variable's.
+// They are grouped by the scope declaring them by groupByScope instead.
+func findGlobalReferences(t *sitter.Node, source []byte, globals packageGlobals) map[string]FunctionCallRef {
+	refs := map[string]FunctionCallRef{}
+	iter := sitter.NewIterator(t, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() != "identifier" {
+			return nil
+		}
+		name := node.Content(source)
+		if !globals.names[name] {
+			return nil
+		}
+		refs[callSite(node)] = FunctionCallRef{Name: name, Ref: node}
+		return nil
+	})
+	return refs
+}
+
+// initDefinitions returns the init functions which refer to any of the given package-level names,
+// since their side effects determine the values the target sees.
+func initDefinitions(globals packageGlobals, referenced []string) []types.Definition {
+	defs := []types.Definition{}
+	for _, init := range globals.inits {
+		for _, name := range referenced {
+			if init.refs[name] {
+				defs = append(defs, types.Definition{
+					Name:     "init",
+					Filepath: init.filepath,
+					Line:     init.line,
+					Column:   1,
+					Source:   init.source,
+				})
+				break
+			}
+		}
+	}
+	return defs
+}
@@ -0,0 +1,269 @@
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// testUtilPackageNames are the directory names of packages which conventionally hold shared test helpers.
+var testUtilPackageNames = map[string]bool{
+	"testutil":    true,
+	"testutils":   true,
+	"testhelper":  true,
+	"testhelpers": true,
+	"testing":     true,
+	"testsupport": true,
+}
+
+// maxFixtures limits how many files from a testdata directory are listed.
+const maxFixtures = 50
+
+// TestContext describes the test setup already available to a package.
+type TestContext struct {
+	// Helpers are the doc comments and signatures of the functions which take a *testing.T
+	// or testing.TB, from the package's test files and from shared test utility packages.
+	Helpers []string
+	// Fixtures are the paths of the files in the package's testdata directory, relative to the package.
+	Fixtures []string
+	// TestMain is the source of the package's TestMain function, if it has one.
+	TestMain string
+}
+
+// FindModule returns the root directory and the module path of the module containing dir.
+func FindModule(dir string) (root string, modulePath string, err error) {
+	current, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("could not resolve directory: %w", err)
+	}
+	for {
+		goMod := filepath.Join(current, "go.mod")
+		if file, err := os.Open(goMod); err == nil {
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if strings.HasPrefix(line, "module ") {
+					return current, strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`), nil
+				}
+			}
+			return "", "", fmt.Errorf("no module directive found in %q", goMod)
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", "", fmt.Errorf("could not find go.mod above %q", dir)
+		}
+		current = parent
+	}
+}
+
+// ImportPath returns the import path of the package containing the given file,
+// including packages of the standard library and vendored packages.
+func ImportPath(file string) (string, error) {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return "", fmt.Errorf("could not resolve directory: %w", err)
+	}
+	root, modulePath, err := FindModule(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve package directory: %w", err)
+	}
+	rel = filepath.ToSlash(rel)
+	if idx := strings.LastIndex("/"+rel, "/vendor/"); idx != -1 {
+		return rel[idx+len("/vendor/")-1:], nil
+	}
+	switch {
+	case modulePath == "std" && rel != ".":
+		// packages of the standard library are imported without the module path, unlike those of cmd
+		return rel, nil
+	case rel == ".":
+		return modulePath, nil
+	}
+	return path.Join(modulePath, rel), nil
+}
+
+// InModule reports whether the file belongs to the module rooted at the given directory,
+// rather than to a nested module or a vendored package.
+func InModule(moduleRoot string, file string) bool {
+	root, _, err := FindModule(filepath.Dir(file))
+	if err != nil || filepath.Clean(root) != filepath.Clean(moduleRoot) {
+		return false
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return !strings.HasPrefix(rel, "vendor/") && !strings.Contains(rel, "/vendor/")
+}
+
+// MatchImportPath reports whether the import path matches any of the patterns, which are either
+// import paths, globs such as 'github.com/acme/*', or end in '/...' to include subpackages.
+func MatchImportPath(patterns []string, importPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, importPath); ok {
+			return true
+		}
+		if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+			if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindTestContext collects the helpers, fixtures, and TestMain setup available to tests of the given package.
+func FindTestContext(packageDir string) (TestContext, error) {
+	testContext := TestContext{}
+
+	functions, err := ListPackageTestFunctions(packageDir)
+	if err != nil {
+		return TestContext{}, err
+	}
+	for _, function := range functions {
+		if function.Name == "TestMain" {
+			testContext.TestMain = function.Source
+			continue
+		}
+		if !function.IsTest() && function.Receiver == "" && function.TakesTesting {
+			testContext.Helpers = append(testContext.Helpers, helperDeclaration(function, ""))
+		}
+	}
+
+	sharedHelpers, err := findSharedTestHelpers(packageDir)
+	if err != nil {
+		return TestContext{}, err
+	}
+	testContext.Helpers = append(testContext.Helpers, sharedHelpers...)
+
+	testContext.Fixtures, err = listFixtures(packageDir)
+	if err != nil {
+		return TestContext{}, err
+	}
+	return testContext, nil
+}
+
+// helperDeclaration returns the doc comment and signature of the helper, noting its import path if it is in another package.
+func helperDeclaration(function TestFunction, importPath string) string {
+	comments := []string{}
+	for _, line := range strings.Split(function.Source, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			break
+		}
+		comments = append(comments, line)
+	}
+	if importPath != "" {
+		comments = append(comments, fmt.Sprintf("// imported from %q", importPath))
+	}
+	return strings.Join(append(comments, function.Signature), "\n")
+}
+
+// findSharedTestHelpers returns the exported helpers of the test utility packages within the module.
+func findSharedTestHelpers(packageDir string) ([]string, error) {
+	root, modulePath, err := FindModule(packageDir)
+	if err != nil {
+		// without a module there is no way to import shared helpers
+		return nil, nil
+	}
+	absPackageDir, err := filepath.Abs(packageDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve directory: %w", err)
+	}
+
+	helpers := []string{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+		if !testUtilPackageNames[name] || path == absPackageDir {
+			return nil
+		}
+		files, err := filepath.Glob(filepath.Join(path, "*.go"))
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		importPath := modulePath + "/" + filepath.ToSlash(rel)
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			code, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("could not read file: %w", err)
+			}
+			functions, err := ListTestFunctions(file, code)
+			if err != nil {
+				return fmt.Errorf("could not parse %q: %w", file, err)
+			}
+			for _, function := range functions {
+				if function.TakesTesting && function.Receiver == "" && IsExported(function.Name) {
+					helpers = append(helpers, helperDeclaration(function, importPath))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not search for test helper packages: %w", err)
+	}
+	return helpers, nil
+}
+
+// listFixtures returns the files within the testdata directory of the package.
+func listFixtures(packageDir string) ([]string, error) {
+	testdata := filepath.Join(packageDir, "testdata")
+	if _, err := os.Stat(testdata); os.IsNotExist(err) {
+		return nil, nil
+	}
+	fixtures := []string{}
+	err := filepath.Walk(testdata, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(fixtures) >= maxFixtures {
+			return filepath.SkipAll
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(packageDir, path)
+		if err != nil {
+			return err
+		}
+		fixtures = append(fixtures, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list testdata: %w", err)
+	}
+	return fixtures, nil
+}
+
+// IsExported reports whether the name starts with an upper-case letter.
+func IsExported(name string) bool {
+	for _, r := range name {
+		return unicode.IsUpper(r)
+	}
+	return false
+}
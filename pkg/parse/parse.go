@@ -4,81 +4,49 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/schollz/progressbar/v3"
 	sitter "github.com/smacker/go-tree-sitter"
 	"github.com/smacker/go-tree-sitter/golang"
 
 	"github.com/robotsail/go-create-test/pkg/types"
 )
 
-const queryPattern = `
-(
-  (comment)? @function.comment
-  (function_declaration
-    name: (identifier) @function.name
-    body: (block)
-	) @function.definition
-)
-`
-
-const methodQuery = `
-(
-	((comment) @comment)
-	(method_declaration
-  		name: (field_identifier) @method-name
-  		body: (block) 
-	) @method-declaration
-)
-`
-
 const callExpressionQuerySelector = `(call_expression function: (selector_expression field: (field_identifier) @fieldname) @function)`
 const callExpressionQueryIdentifier = `(call_expression function: (identifier) @name)`
-const queryFunctionNode = `(function_declaration name: (identifier) @function.name) @function`
-
-// findFunction attempts to find a function with the target name in the given source tree.
-// The root declaration node is returned.
-func findFunction(functionName string, t *sitter.Node, source []byte) (*sitter.Node, error) {
-	// create a tree-sitter parser
-	log.Printf("searching for function %q\n", functionName)
-
-	// create a query to extract the golang package name from the code
-	query, err := sitter.NewQuery([]byte(queryFunctionNode), golang.GetLanguage())
-	if err != nil {
-		return nil, fmt.Errorf("could not create query: %w", err)
-	}
 
-	queryCursor := sitter.NewQueryCursor()
-	defer queryCursor.Close()
-
-	tempPtr := t
-	queryCursor.Exec(query, tempPtr)
+// callExpressionQueryOperand matches calls of function values which are looked up or parenthesized,
+// e.g. 'hooks[0]()' or '(fallback)(x)'.
+const callExpressionQueryOperand = `[
+  (call_expression function: (index_expression operand: (_) @operand))
+  (call_expression function: (parenthesized_expression (_) @operand))
+]`
 
-	// var startByte, endByte uint32
-	var capturedNode *sitter.Node
-	for {
-		match, ok := queryCursor.NextMatch()
-		if !ok {
-			break
-		}
-		function := match.Captures[0]
-		funcName := match.Captures[1]
-		if funcName.Node.Content(source) == functionName {
-			capturedNode = function.Node
-			break
-		}
-	}
-	return capturedNode, nil
+// findFunction attempts to find a function or method with the target name in the given source tree.
+// The name of a method may be qualified by its receiver type, e.g. 'Server.Start'.
+// The root declaration node is returned.
+func findFunction(logger *slog.Logger, functionName string, t *sitter.Node, source []byte) (*sitter.Node, error) {
+	logger.Debug("searching for function", "function", functionName)
+	return findDeclaration(functionName, t, source), nil
 }
 
+// DefinitionProgress is called after each definition is resolved, with the number resolved so far and in total.
+type DefinitionProgress func(resolved int, total int)
+
 // GetFunctionCalls takes a given function name and file to look at, then
-// returns the definitions of all of the symbols referred to by that function.
-func GetFunctionCalls(filepath string, functionName string, code []byte) ([]string, error) {
-	log.Println("parsing code")
+// returns the definitions of all of the symbols referred to by that function,
+// including the package-level variables and constants it refers to, and the init functions which refer to them.
+// Functions declared outside of the module are summarized by their signature and the first sentence
+// of their doc comment, unless their import path matches one of the fullSource patterns.
+// The name of a method may be qualified by its receiver type, e.g. 'Server.Start'.
+// The progress callback is optional.
+func GetFunctionCalls(logger *slog.Logger, filepath string, functionName string, code []byte, fullSource []string, progress DefinitionProgress) ([]types.Definition, error) {
+	logger.Debug("parsing code", "file", filepath)
 	parser := sitter.NewParser()
 	parser.SetLanguage(golang.GetLanguage())
 
@@ -94,7 +62,7 @@ func GetFunctionCalls(filepath string, functionName string, code []byte) ([]stri
 	}
 	defer tree.Close()
 
-	targetFunction, err := findFunction(functionName, tree.RootNode(), code)
+	targetFunction, err := findFunction(logger, functionName, tree.RootNode(), code)
 	if err != nil {
 		return nil, fmt.Errorf("could not find function %q: %w", functionName, err)
 	}
@@ -102,29 +70,51 @@ func GetFunctionCalls(filepath string, functionName string, code []byte) ([]stri
 		return nil, fmt.Errorf("could not find function %q", functionName)
 	}
 
-	log.Println("scanning for function calls")
+	logger.Debug("scanning for function calls", "function", functionName)
 
-	functionCalls, err := findFunctionCalls(targetFunction, code)
+	functionCalls, err := findFunctionCalls(logger, targetFunction, code)
 	if err != nil {
 		return nil, fmt.Errorf("could not find function calls: %w", err)
 	}
 	if functionCalls == nil {
 		return nil, fmt.Errorf("could not find function calls")
 	}
-	for call, funcNode := range functionCalls {
-		log.Printf("Found function call '%s' at '%d:%d'\n", call, funcNode.Ref.StartPoint().Row, funcNode.Ref.StartPoint().Column)
+	// package-level variables and constants are resolved along with the calls
+	globals, err := readPackageGlobals(logger, filepath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read package-level declarations: %w", err)
+	}
+	for _, ref := range findGlobalReferences(targetFunction, code, globals) {
+		if _, ok := functionCalls[callSite(ref.Ref)]; !ok {
+			functionCalls[callSite(ref.Ref)] = ref
+		}
+	}
+	// calls of the same symbol are resolved once, rather than once per call site
+	functionCalls = groupByScope(targetFunction, functionCalls, code)
+	for _, funcNode := range functionCalls {
+		logger.Debug("found function call", "call", funcNode.Name, "row", funcNode.Ref.StartPoint().Row, "column", funcNode.Ref.StartPoint().Column)
 	}
 
-	functionDefs, err := findDefinitions(filepath, functionCalls, code)
+	functionDefs, err := findDefinitions(logger, filepath, functionCalls, code, progress)
 	if err != nil {
 		return nil, fmt.Errorf("error finding definitions: %v", err)
 	}
+	functionDefs = withoutLocalDefinitions(logger, filepath, targetFunction, functionDefs)
 
-	defs, err := readFunctionDefinitions(functionDefs)
+	defs, err := readFunctionDefinitions(logger, filepath, functionDefs, fullSource)
 	if err != nil {
 		return nil, fmt.Errorf("error reading function definitions: %v", err)
 	}
-	return defs, nil
+	if functionName == "init" {
+		return defs, nil
+	}
+	referenced := []string{}
+	for _, def := range functionDefs {
+		if globals.names[def.FunctionName] {
+			referenced = append(referenced, def.FunctionName)
+		}
+	}
+	return append(defs, initDefinitions(globals, referenced)...), nil
 }
 
 // nodeName returns the name of the given node.
@@ -148,15 +138,15 @@ func functionDefinitionFromSelector(t *sitter.Node, source []byte) *sitter.Node
 }
 
 // getFunctionLocation Returns the location of the function definition.
-func getFunctionLocation(t *sitter.Node, source []byte) *sitter.Node {
-	log.Printf("checking function, type is %s\n", t.Type())
+func getFunctionLocation(logger *slog.Logger, t *sitter.Node, source []byte) *sitter.Node {
+	logger.Debug("checking function", "type", t.Type())
 	if t.Type() == "identifier" {
-		log.Printf("Found function '%s' at '%d:%d'\n", nodeName(t, source), t.StartPoint().Row, t.StartPoint().Column)
+		logger.Debug("found function", "function", nodeName(t, source), "row", t.StartPoint().Row, "column", t.StartPoint().Column)
 		return t
 	}
 	if t.Type() == "selector_expression" {
 		funcDef := functionDefinitionFromSelector(t, source)
-		log.Printf("function '%s' of '%s' is located at '%d:%d'\n", nodeName(funcDef, source), nodeName(t, source), funcDef.StartPoint().Row, funcDef.StartPoint().Column)
+		logger.Debug("found method", "function", nodeName(funcDef, source), "selector", nodeName(t, source), "row", funcDef.StartPoint().Row, "column", funcDef.StartPoint().Column)
 		return funcDef
 	}
 	return nil
@@ -168,7 +158,12 @@ type FunctionCallRef struct {
 	Filepath string
 }
 
-func findDirectFunctionCalls(t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
+// callSite returns the position of the given node, which calls are keyed by.
+func callSite(ref *sitter.Node) string {
+	return fmt.Sprintf("%d:%d", ref.StartPoint().Row, ref.StartPoint().Column)
+}
+
+func findDirectFunctionCalls(logger *slog.Logger, t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
 	// create a tree-sitter parser
 	functionName := t.ChildByFieldName("name")
 	if functionName == nil {
@@ -196,7 +191,7 @@ func findDirectFunctionCalls(t *sitter.Node, source []byte) (map[string]Function
 			break
 		}
 		if len(match.Captures) == 0 {
-			fmt.Println("no captures, skipping")
+			logger.Debug("no captures, skipping")
 			continue
 		}
 		functionCallNode := match.Captures[0].Node
@@ -204,16 +199,12 @@ func findDirectFunctionCalls(t *sitter.Node, source []byte) (map[string]Function
 			Name: nodeName(functionCallNode, source),
 			Ref:  functionCallNode,
 		}
-		// check if function call already exists in calls
-		if _, ok := calls[functionCall.Name]; ok {
-			continue
-		}
-		calls[functionCall.Name] = functionCall
+		calls[callSite(functionCallNode)] = functionCall
 	}
 	return calls, nil
 }
 
-func callFromQuery(match *sitter.QueryMatch, code []byte) (name string, field *sitter.Node) {
+func callFromQuery(logger *slog.Logger, match *sitter.QueryMatch, code []byte) (name string, field *sitter.Node) {
 	for _, capture := range match.Captures {
 		node := capture.Node
 		switch node.Type() {
@@ -222,14 +213,14 @@ func callFromQuery(match *sitter.QueryMatch, code []byte) (name string, field *s
 		case "field_identifier":
 			field = node
 		default:
-			log.Printf("unknown node type %s", node.Type())
+			logger.Debug("unknown node type", "type", node.Type())
 			break
 		}
 	}
 	return
 }
 
-func findSelectExpressionCalls(t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
+func findSelectExpressionCalls(logger *slog.Logger, t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
 	// create a tree-sitter parser
 	functionName := t.ChildByFieldName("name")
 	if functionName == nil {
@@ -256,11 +247,11 @@ func findSelectExpressionCalls(t *sitter.Node, source []byte) (map[string]Functi
 		if !ok {
 			break
 		}
-		name, field := callFromQuery(match, source)
-		if _, ok := calls[name]; ok {
+		name, field := callFromQuery(logger, match, source)
+		if field == nil {
 			continue
 		}
-		calls[name] = FunctionCallRef{
+		calls[callSite(field)] = FunctionCallRef{
 			Name: name,
 			Ref:  field,
 		}
@@ -269,19 +260,65 @@ func findSelectExpressionCalls(t *sitter.Node, source []byte) (map[string]Functi
 
 }
 
-// findFunctionCalls Returns a list of function calls in the source code
-func findFunctionCalls(t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
-	calls, err := findDirectFunctionCalls(t, source)
+// findOperandCalls returns the calls of function values held in variables or fields, which are
+// looked up by index or parenthesized before being called.
+func findOperandCalls(logger *slog.Logger, t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
+	query, err := sitter.NewQuery([]byte(callExpressionQueryOperand), golang.GetLanguage())
+	if err != nil {
+		return nil, fmt.Errorf("could not create query: %w", err)
+	}
+
+	queryCursor := sitter.NewQueryCursor()
+	defer queryCursor.Close()
+	queryCursor.Exec(query, t)
+
+	calls := map[string]FunctionCallRef{}
+	for {
+		match, ok := queryCursor.NextMatch()
+		if !ok {
+			break
+		}
+		for _, capture := range match.Captures {
+			operand := capture.Node
+			ref := getFunctionLocation(logger, operand, source)
+			if ref == nil {
+				// e.g. calls of the result of another call, which have no definition of their own
+				continue
+			}
+			name := nodeName(operand, source)
+			calls[callSite(ref)] = FunctionCallRef{
+				Name: name,
+				Ref:  ref,
+			}
+		}
+	}
+	return calls, nil
+}
+
+// findFunctionCalls Returns a list of function calls in the source code,
+// including those within function literals and defer and go statements.
+// Calls are keyed by their call site rather than by their text, since the same text may refer to
+// different symbols, e.g. a local variable shadowing a package-level one. Calls referring to the same
+// symbol are grouped by groupByScope before they are resolved.
+func findFunctionCalls(logger *slog.Logger, t *sitter.Node, source []byte) (map[string]FunctionCallRef, error) {
+	calls, err := findDirectFunctionCalls(logger, t, source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get direct function calls: %w", err)
 	}
-	selectCalls, err := findSelectExpressionCalls(t, source)
+	selectCalls, err := findSelectExpressionCalls(logger, t, source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get select expression calls: %w", err)
 	}
 	for k, v := range selectCalls {
 		calls[k] = v
 	}
+	operandCalls, err := findOperandCalls(logger, t, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get calls of function values: %w", err)
+	}
+	for k, v := range operandCalls {
+		calls[k] = v
+	}
 	return calls, nil
 }
 
@@ -318,195 +355,135 @@ func DefinitionStringFromGopls(definition string) (string, sitter.Point, error)
 	return filepath, startPoint, nil
 }
 
-func findDefinitions(filename string, calls map[string]FunctionCallRef, code []byte) ([]types.DefinitionLocation, error) {
+// findDefinitions resolves the definitions of the calls with gopls, in the order the calls appear in the source.
+// Calls are keyed by the position of their definition rather than by their text, so that calls of
+// the same callee through different expressions result in a single definition.
+func findDefinitions(logger *slog.Logger, filename string, calls map[string]FunctionCallRef, code []byte, progress DefinitionProgress) ([]types.DefinitionLocation, error) {
+	ordered := make([]FunctionCallRef, 0, len(calls))
+	for _, call := range calls {
+		ordered = append(ordered, call)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].Ref.StartByte() < ordered[j].Ref.StartByte()
+	})
+
 	definitions := []types.DefinitionLocation{}
+	resolved := map[string]bool{}
 	fileSize := len(calls)
-	bar := progressbar.Default(int64(fileSize))
-	for _, call := range calls {
+	for i, call := range ordered {
 		params := fmt.Sprintf("%s:%d:%d", filename, call.Ref.StartPoint().Row+1, call.Ref.StartPoint().Column+1)
 		command := exec.Command("gopls", "definition", params)
 		out, err := command.Output()
 		if err != nil {
 			stderr, ok := err.(*exec.ExitError)
 			if ok {
-				log.Printf("error running gopls definition, params used: %s\n", params)
+				logger.Error("error running gopls definition", "params", params)
 				return nil, fmt.Errorf("error running gopls definition: '%s', error: %w", string(stderr.Stderr), err)
 			}
 			return nil, fmt.Errorf("error running gopls definition: %w", err)
 		}
-		filepath, _, err := DefinitionStringFromGopls(string(out))
+		filepath, location, err := DefinitionStringFromGopls(string(out))
 		if err != nil {
 			return nil, fmt.Errorf("error parsing gopls definition: %w", err)
 		}
-		// definitionRange, err := getDefinitionRange(filepath, location)
-		if err != nil {
-			return nil, fmt.Errorf("error getting definition range: %w", err)
+		if progress != nil {
+			progress(i+1, fileSize)
 		}
-		// log.Printf("definition range for '%s' is '%+v'\n", nodeName(call, code), definitionRange)
+		identity := fmt.Sprintf("%s:%d:%d", filepath, location.Row, location.Column)
+		if resolved[identity] {
+			logger.Debug("call resolves to a definition found already", "call", call.Name, "definition", identity)
+			continue
+		}
+		resolved[identity] = true
 		definitions = append(definitions, types.DefinitionLocation{
 			Filepath:     filepath,
 			FunctionName: call.Name,
-			// Start:    definitionRange.Start,
-			// End:      definitionRange.End,
+			Start:        location,
 		})
-		bar.Add(1)
 	}
 	return definitions, nil
 }
 
-// getSplitRange takes a range string like 'row:col-row:col' and returns it in a serialized format.
-func getSplitRange(rangeString string) (types.Range, error) {
-	// validate that string matches row:col-row:col
-	if !strings.Contains(rangeString, "-") {
-		return types.Range{}, fmt.Errorf("invalid range string: %s", rangeString)
-	}
-	splitRange := strings.Split(rangeString, "-")
-	start := strings.Split(splitRange[0], ":")
-	end := strings.Split(splitRange[1], ":")
-	startRow, err := strconv.ParseUint(start[0], 10, 32)
+// withoutLocalDefinitions drops the definitions declared within the target function, such as closures
+// assigned to local variables, since they are part of the target's source already.
+func withoutLocalDefinitions(logger *slog.Logger, file string, target *sitter.Node, defs []types.DefinitionLocation) []types.DefinitionLocation {
+	absPath, err := filepath.Abs(file)
 	if err != nil {
-		return types.Range{}, fmt.Errorf("invalid start row: %s", start[0])
+		return defs
 	}
-	startCol, err := strconv.ParseUint(start[1], 10, 32)
-	if err != nil {
-		return types.Range{}, fmt.Errorf("invalid start column: %s", start[1])
-	}
-	endRow, err := strconv.ParseUint(end[0], 10, 32)
-	if err != nil {
-		return types.Range{}, fmt.Errorf("invalid end row: %s", end[0])
-	}
-	endCol, err := strconv.ParseUint(end[1], 10, 32)
-	if err != nil {
-		return types.Range{}, fmt.Errorf("invalid end column: %s", end[1])
-	}
-	return types.Range{
-		Start: sitter.Point{
-			Row:    uint32(startRow),
-			Column: uint32(startCol),
-		},
-		End: sitter.Point{
-			Row:    uint32(endRow),
-			Column: uint32(endCol),
-		},
-	}, nil
-}
-
-// getDefinitionRange finds the function definition range.
-//
-//	string - the range where the function is defined on
-func getDefinitionRange(filepath string, location sitter.Point) (types.Range, error) {
-	foldingRangeCmd := exec.Command("gopls", "folding_ranges", filepath)
-	foldingRangeBytes, err := foldingRangeCmd.Output()
-	if err != nil {
-		return types.Range{}, fmt.Errorf("error running gopls folding_ranges: %w", err)
-	}
-	foldingRange := string(foldingRangeBytes)
-	foldingRange = strings.TrimSpace(foldingRange)
-	foldingRanges := strings.Split(foldingRange, "\n")
-
-	// convert to ranges
-	ranges := []types.Range{}
-	for _, rangeLine := range foldingRanges {
-		symbolRange, err := getSplitRange(rangeLine)
-		if err != nil {
-			log.Printf("could not parse range: %v\n", err)
+	kept := []types.DefinitionLocation{}
+	for _, def := range defs {
+		// gopls rows are one-indexed, while tree-sitter rows are zero-indexed
+		row := def.Start.Row - 1
+		if def.Filepath == absPath && row >= target.StartPoint().Row && row <= target.EndPoint().Row {
+			logger.Debug("skipping local definition", "symbol", def.FunctionName, "row", def.Start.Row)
 			continue
 		}
-		ranges = append(ranges, symbolRange)
+		kept = append(kept, def)
 	}
-
-	// collect only the matching ranges
-	biggestRange := types.Range{}
-	for i := 0; i < len(ranges); i++ {
-		if ranges[i].Start.Row == location.Row && ranges[i].End.Row > biggestRange.End.Row {
-			biggestRange = ranges[i]
-		}
-	}
-	return biggestRange, nil
+	return kept
 }
 
-func getFunctionComments(fileLines []string, start int) (comments []string) {
-	for i := start; i >= 0 && i < len(fileLines); i-- {
-		if strings.HasPrefix(fileLines[i], "//") {
-			comments = append(comments, fileLines[i])
-		} else {
-			break
-		}
-	}
-	// reverse list so that comments appear in the correct order
-	for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
-		comments[i], comments[j] = comments[j], comments[i]
+func readFunctionDefinitions(logger *slog.Logger, target string, defs []types.DefinitionLocation, fullSource []string) ([]types.Definition, error) {
+	// without a module, every definition is included in full
+	moduleRoot, _, err := FindModule(filepath.Dir(target))
+	if err != nil {
+		logger.Debug("could not find the module of the target", "error", err)
 	}
-	return
-}
-
-func readFunctionDefinitions(defs []types.DefinitionLocation) ([]string, error) {
-	contents := []string{}
+	contents := []types.Definition{}
+	seen := map[string]bool{}
 	for _, def := range defs {
 		// read in the given filepath and get the function definition
 		file, err := ioutil.ReadFile(def.Filepath)
 		if err != nil {
 			return nil, fmt.Errorf("could not open file: %w", err)
 		}
-		// extract the content at the range specified
-		searchName := def.FunctionName
-		if strings.Contains(searchName, ".") {
-			parts := strings.Split(searchName, ".")
-			funcName := parts[1]
-			searchName = funcName
+		if moduleRoot != "" && !InModule(moduleRoot, def.Filepath) {
+			importPath, err := ImportPath(def.Filepath)
+			if err != nil {
+				logger.Debug("could not find import path", "file", def.Filepath, "error", err)
+			}
+			if err != nil || !MatchImportPath(fullSource, importPath) {
+				summary, err := GetSummaryAt(file, def.Start, importPath)
+				if err == nil {
+					logger.Debug("summarizing definition outside of the module", "symbol", def.FunctionName, "package", importPath)
+					contents = append(contents, types.Definition{
+						Name:     def.FunctionName,
+						Filepath: def.Filepath,
+						Line:     int(def.Start.Row),
+						Column:   int(def.Start.Column),
+						Source:   summary,
+					})
+					continue
+				}
+				// e.g. a local variable of a function outside the module, which is short enough to include in full
+			}
 		}
-		functionDef, err := GetFunctionDefinition(searchName, file)
+		// the declaration is found by its position, since the file may declare several symbols
+		// of the same name, such as the 'Close' methods of different types
+		functionDef, err := GetDeclarationAt(file, def.Start)
 		if err != nil {
-			fmt.Println("could not find function definition, skipping")
+			logger.Warn("could not find definition, skipping", "symbol", def.FunctionName, "error", err)
 			continue
 		}
-		if strings.TrimSpace(functionDef) == "" {
-			log.Printf("could not find function definition for %q\n", def.FunctionName)
+		// several fields of the same struct resolve to its declaration
+		if seen[functionDef] {
+			continue
 		}
-		contents = append(contents, functionDef)
+		seen[functionDef] = true
+		contents = append(contents, types.Definition{
+			Name:     def.FunctionName,
+			Filepath: def.Filepath,
+			Line:     int(def.Start.Row),
+			Column:   int(def.Start.Column),
+			Source:   functionDef,
+		})
 	}
 	return contents, nil
 }
 
-type FunctionDefinition struct {
-	Declaration string
-	Comment     string
-	Name        string
-}
-
-func functionFromQuery(match *sitter.QueryMatch, code []byte) FunctionDefinition {
-	function := FunctionDefinition{}
-	for _, capture := range match.Captures {
-		node := capture.Node
-		switch node.Type() {
-		case "function_declaration":
-			function.Declaration = node.Content(code)
-		case "comment":
-			function.Comment = node.Content(code)
-		case "identifier":
-			function.Name = node.Content(code)
-		}
-	}
-	return function
-}
-
-func methodFunctionFromQuery(match *sitter.QueryMatch, code []byte) FunctionDefinition {
-	function := FunctionDefinition{}
-	for _, capture := range match.Captures {
-		node := capture.Node
-		switch node.Type() {
-		case "comment":
-			function.Comment = node.Content(code)
-		case "field_identifier":
-			function.Name = node.Content(code)
-		case "method_declaration":
-			function.Declaration = node.Content(code)
-		}
-	}
-	return function
-}
-
-// GetFunctionDefinition Returns the definition of a given function within a given file.
+// GetFunctionDefinition Returns the definition of a given function or method within a given file,
+// including its doc comment. The name of a method may be qualified by its receiver type, e.g. 'Server.Start'.
 func GetFunctionDefinition(targetFuncName string, code []byte) (string, error) {
 	// create a tree-sitter parser
 	parser := sitter.NewParser()
@@ -524,79 +501,16 @@ func GetFunctionDefinition(targetFuncName string, code []byte) (string, error) {
 	}
 	defer tree.Close()
 
-	root := tree.RootNode()
-	targetFunc := getFunctionDefinitionQuery(targetFuncName, root, code)
-	if targetFunc == nil {
-		targetFunc = getMethodDefinitionQuery(targetFuncName, root, code)
-	}
+	targetFunc := findDeclaration(targetFuncName, tree.RootNode(), code)
 	if targetFunc == nil {
 		return "", fmt.Errorf("could not find function definition for %q", targetFuncName)
 	}
-
-	return reconstructionDefinition(*targetFunc), nil
-}
-
-func getFunctionDefinitionQuery(targetFuncName string, root *sitter.Node, code []byte) *FunctionDefinition {
-	query, err := sitter.NewQuery([]byte(queryPattern), golang.GetLanguage())
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	queryCursor := sitter.NewQueryCursor()
-	defer queryCursor.Close()
-	queryCursor.Exec(query, root)
-
-	var targetFunc *FunctionDefinition
-	for {
-		match, ok := queryCursor.NextMatch()
-		if !ok {
-			break
-		}
-		function := functionFromQuery(match, code)
-		if function.Name == targetFuncName {
-			targetFunc = &function
-			break
-		}
-	}
-	return targetFunc
-}
-
-func getMethodDefinitionQuery(targetFuncName string, root *sitter.Node, code []byte) *FunctionDefinition {
-	query, err := sitter.NewQuery([]byte(methodQuery), golang.GetLanguage())
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	queryCursor := sitter.NewQueryCursor()
-	defer queryCursor.Close()
-	queryCursor.Exec(query, root)
-
-	var targetFunc *FunctionDefinition
-	for {
-		match, ok := queryCursor.NextMatch()
-		if !ok {
-			break
-		}
-		function := methodFunctionFromQuery(match, code)
-		if function.Name == targetFuncName {
-			targetFunc = &function
-			break
-		}
-	}
-	return targetFunc
-}
-
-func reconstructionDefinition(function FunctionDefinition) string {
-	if strings.TrimSpace(function.Comment) == "" {
-		return function.Declaration
-	}
-	return fmt.Sprintf("%s\n%s", function.Comment, function.Declaration)
+	return withComments(targetFunc, code), nil
 }
 
 // GetPackageName Queries the given file for the package name.
 func GetPackageName(code []byte) (string, error) {
 	// create a tree-sitter parser
-	log.Println("getting package name")
 	parser := sitter.NewParser()
 	parser.SetLanguage(golang.GetLanguage())
 
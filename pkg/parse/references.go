@@ -0,0 +1,150 @@
+package parse
+
+import (
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// structFields returns the types of the named fields of the struct types declared in the file,
+// keyed by the name of the struct type and then of the field, e.g. the 'srv' field of a test suite.
+func structFields(root *sitter.Node, source []byte) map[string]map[string]string {
+	fields := map[string]map[string]string{}
+	iter := sitter.NewIterator(root, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() != "type_spec" {
+			return nil
+		}
+		name, structType := node.ChildByFieldName("name"), node.ChildByFieldName("type")
+		if name == nil || structType == nil || structType.Type() != "struct_type" {
+			return nil
+		}
+		typeFields := map[string]string{}
+		fieldIter := sitter.NewIterator(structType, sitter.DFSMode)
+		_ = fieldIter.ForEach(func(field *sitter.Node) error {
+			if field.Type() != "field_declaration" {
+				return nil
+			}
+			fieldType := typeName(field.ChildByFieldName("type"), source)
+			for i := 0; i < int(field.ChildCount()); i++ {
+				if field.FieldNameForChild(i) == "name" {
+					typeFields[field.Child(i).Content(source)] = fieldType
+				}
+			}
+			return nil
+		})
+		fields[name.Content(source)] = typeFields
+		return nil
+	})
+	return fields
+}
+
+// typeName returns the name of the named type of a type expression, without any pointer, package qualifier,
+// or type arguments, e.g. 'Server' for '*http.Server', or an empty string if it is not a named type.
+func typeName(t *sitter.Node, source []byte) string {
+	if t == nil {
+		return ""
+	}
+	switch t.Type() {
+	case "type_identifier":
+		return t.Content(source)
+	case "pointer_type", "parenthesized_type":
+		return typeName(t.NamedChild(0), source)
+	case "generic_type":
+		return typeName(t.ChildByFieldName("type"), source)
+	case "qualified_type":
+		return typeName(t.ChildByFieldName("name"), source)
+	}
+	return ""
+}
+
+// localTypes returns the names of the types of the parameters and variables declared within the function,
+// including its receiver, where they can be told from their declaration. Scopes are not told apart.
+func localTypes(function *sitter.Node, source []byte, fields map[string]map[string]string) map[string]string {
+	locals := map[string]string{}
+	iter := sitter.NewIterator(function, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		switch node.Type() {
+		case "parameter_declaration", "variadic_parameter_declaration":
+			declared := typeName(node.ChildByFieldName("type"), source)
+			for i := 0; i < int(node.ChildCount()); i++ {
+				if node.FieldNameForChild(i) == "name" {
+					locals[node.Child(i).Content(source)] = declared
+				}
+			}
+		case "var_spec":
+			names, values := []*sitter.Node{}, node.ChildByFieldName("value")
+			for i := 0; i < int(node.ChildCount()); i++ {
+				if node.FieldNameForChild(i) == "name" {
+					names = append(names, node.Child(i))
+				}
+			}
+			declared := typeName(node.ChildByFieldName("type"), source)
+			for i, name := range names {
+				if declared == "" && values != nil && i < int(values.NamedChildCount()) {
+					locals[name.Content(source)] = expressionType(values.NamedChild(i), locals, fields, source)
+				} else {
+					locals[name.Content(source)] = declared
+				}
+			}
+		case "short_var_declaration":
+			left, right := node.ChildByFieldName("left"), node.ChildByFieldName("right")
+			if left == nil || right == nil {
+				return nil
+			}
+			// with a single call on the right, only the first of its results is told apart, e.g. 'srv, err := NewServer()'
+			for i := 0; i < int(left.NamedChildCount()) && i < int(right.NamedChildCount()); i++ {
+				locals[left.NamedChild(i).Content(source)] = expressionType(right.NamedChild(i), locals, fields, source)
+			}
+		}
+		return nil
+	})
+	return locals
+}
+
+// expressionType returns the name of the type of the expression, or an empty string if it cannot be told
+// from the expression itself and the known types of locals and struct fields. Values returned by functions
+// named after a type, following the 'NewServer' convention for constructors, are assumed to be of that type.
+func expressionType(expr *sitter.Node, locals map[string]string, fields map[string]map[string]string, source []byte) string {
+	if expr == nil {
+		return ""
+	}
+	switch expr.Type() {
+	case "identifier":
+		return locals[expr.Content(source)]
+	case "selector_expression":
+		operand := expressionType(expr.ChildByFieldName("operand"), locals, fields, source)
+		field := expr.ChildByFieldName("field")
+		if operand == "" || field == nil {
+			return ""
+		}
+		return fields[operand][field.Content(source)]
+	case "parenthesized_expression", "unary_expression":
+		return expressionType(expr.NamedChild(0), locals, fields, source)
+	case "composite_literal":
+		return typeName(expr.ChildByFieldName("type"), source)
+	case "call_expression":
+		function := expr.ChildByFieldName("function")
+		if function != nil && function.Type() == "selector_expression" {
+			function = function.ChildByFieldName("field")
+		}
+		if function == nil {
+			return ""
+		}
+		name := function.Content(source)
+		if name == "new" {
+			if args := expr.ChildByFieldName("arguments"); args != nil && args.NamedChildCount() == 1 {
+				// the type may be parsed as an expression, e.g. 'new(Server)'
+				if arg := args.NamedChild(0); arg.Type() == "identifier" {
+					return arg.Content(source)
+				}
+				return typeName(args.NamedChild(0), source)
+			}
+			return ""
+		}
+		if constructed := strings.TrimPrefix(name, "New"); constructed != name && IsExported(constructed) {
+			return constructed
+		}
+	}
+	return ""
+}
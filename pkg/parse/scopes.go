@@ -0,0 +1,141 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// scopeTypes are the node types which open a scope that local names may be declared in.
+var scopeTypes = map[string]bool{
+	"function_declaration":        true,
+	"method_declaration":          true,
+	"func_literal":                true,
+	"block":                       true,
+	"if_statement":                true,
+	"for_statement":               true,
+	"expression_switch_statement": true,
+	"type_switch_statement":       true,
+	"expression_case":             true,
+	"type_case":                   true,
+	"default_case":                true,
+	"communication_case":          true,
+}
+
+// declaringFields are the fields holding the names declared by a node, keyed by its type.
+var declaringFields = map[string]string{
+	"short_var_declaration":          "left",
+	"range_clause":                   "left",
+	"receive_statement":              "left",
+	"var_spec":                       "name",
+	"const_spec":                     "name",
+	"type_spec":                      "name",
+	"parameter_declaration":          "name",
+	"variadic_parameter_declaration": "name",
+}
+
+// groupByScope keeps a single call of every group of calls which refer to the same symbol, so that each
+// symbol is resolved by gopls once rather than once per call site. Calls are grouped by their text and
+// by the innermost scope within the target declaring the name they start with, e.g. every 'fmt.Errorf'
+// forms one group, while 'x.Run' is grouped separately for each declaration of 'x'.
+// The first call of each group, in the order of the source, is kept.
+func groupByScope(target *sitter.Node, calls map[string]FunctionCallRef, source []byte) map[string]FunctionCallRef {
+	sites := make([]string, 0, len(calls))
+	for site := range calls {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		return calls[sites[i]].Ref.StartByte() < calls[sites[j]].Ref.StartByte()
+	})
+
+	grouped := map[string]FunctionCallRef{}
+	seen := map[string]bool{}
+	for _, site := range sites {
+		call := calls[site]
+		scope := declaringScope(target, call.Ref, leadingIdentifier(call.Name), source)
+		group := fmt.Sprintf("%s@%d", call.Name, scope.StartByte())
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+		grouped[site] = call
+	}
+	return grouped
+}
+
+// leadingIdentifier returns the identifier an expression starts with, e.g. 's' for 's.conn.Close'.
+func leadingIdentifier(expression string) string {
+	for i, r := range expression {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return expression[:i]
+		}
+	}
+	return expression
+}
+
+// declaringScope returns the innermost scope within the target in which the name is declared before
+// the given reference, or the target itself when the name is declared outside of it.
+func declaringScope(target *sitter.Node, ref *sitter.Node, name string, source []byte) *sitter.Node {
+	for node := ref.Parent(); node != nil; node = node.Parent() {
+		if scopeTypes[node.Type()] && declares(node, name, ref.StartByte(), source) {
+			return node
+		}
+		if node.Equal(target) {
+			break
+		}
+	}
+	return target
+}
+
+// declares reports whether the scope declares the name before the given offset. Nested scopes are not searched,
+// since their names are not visible within the scope, and neither are declarations which the reference is part of,
+// e.g. 'x := x.Next()', since their names are only visible once they end.
+func declares(scope *sitter.Node, name string, before uint32, source []byte) bool {
+	// the alias of a type switch is declared in each of its clauses, with the type of the clause
+	if parent := scope.Parent(); (scope.Type() == "type_case" || scope.Type() == "default_case") &&
+		parent != nil && parent.Type() == "type_switch_statement" && declaredBy(parent, "alias", name, source) {
+		return true
+	}
+	found := false
+	var visit func(node *sitter.Node)
+	visit = func(node *sitter.Node) {
+		for i := 0; i < int(node.NamedChildCount()) && !found; i++ {
+			child := node.NamedChild(i)
+			if child.StartByte() >= before {
+				return
+			}
+			if scopeTypes[child.Type()] {
+				continue
+			}
+			if field, ok := declaringFields[child.Type()]; ok && child.EndByte() <= before && declaredBy(child, field, name, source) {
+				found = true
+				return
+			}
+			visit(child)
+		}
+	}
+	visit(scope)
+	return found
+}
+
+// declaredBy reports whether any of the node's children in the given field is, or lists, the name,
+// e.g. either of the names of 'var a, b int'.
+func declaredBy(node *sitter.Node, field string, name string, source []byte) bool {
+	for i := 0; i < int(node.ChildCount()); i++ {
+		if node.FieldNameForChild(i) != field {
+			continue
+		}
+		child := node.Child(i)
+		if child.Content(source) == name {
+			return true
+		}
+		for j := 0; j < int(child.NamedChildCount()); j++ {
+			if child.NamedChild(j).Content(source) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/golang"
+
+	"github.com/robotsail/go-create-test/pkg/types"
+)
+
+// TestFunction is a top-level function or method declared within a test file.
+type TestFunction struct {
+	Filepath string
+	Name     string
+	// Receiver is the receiver type of a method, e.g. a testify suite, or empty for functions.
+	Receiver string
+	// Source is the declaration of the function, including its doc comment.
+	Source string
+	// Signature is the declaration of the function without its body.
+	Signature string
+	// Calls are the names of the functions called directly by the test, e.g. 'newTestServer'.
+	Calls []string
+	// References are the names of every function and method called within the function, including those
+	// called through a selector, e.g. 'Parse' for 'config.Parse(src)'. Methods are qualified by the type of
+	// their receiver where it can be told from the test, e.g. 'Server.Close' for 'srv := NewServer(); srv.Close()'.
+	References []string
+	// TakesTesting is true when one of the parameters is a *testing.T or testing.TB.
+	TakesTesting bool
+}
+
+// IsTest reports whether the function is a top-level test, as opposed to a helper, a benchmark, or a method.
+func (f TestFunction) IsTest() bool {
+	return f.Receiver == "" && strings.HasPrefix(f.Name, "Test") && f.Name != "TestMain"
+}
+
+// ListTestFunctions returns every top-level function and method declared in the given test file.
+func ListTestFunctions(filepath string, code []byte) ([]TestFunction, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	root := tree.RootNode()
+	fields := structFields(root, code)
+	functions := []TestFunction{}
+	for i := 0; i < int(root.NamedChildCount()); i++ {
+		node := root.NamedChild(i)
+		if node.Type() != "function_declaration" && node.Type() != "method_declaration" {
+			continue
+		}
+		name := node.ChildByFieldName("name")
+		if name == nil {
+			continue
+		}
+		functions = append(functions, TestFunction{
+			Filepath:     filepath,
+			Name:         name.Content(code),
+			Receiver:     receiverType(node, code),
+			Source:       withComments(node, code),
+			Signature:    signature(node, code),
+			Calls:        calledIdentifiers(node, code),
+			References:   calledNames(node, code, fields),
+			TakesTesting: takesTesting(node, code),
+		})
+	}
+	return functions, nil
+}
+
+// withComments returns the content of the node, preceded by the group of comments directly above it.
+func withComments(t *sitter.Node, source []byte) string {
+	start := t
+	for prev := t.PrevSibling(); prev != nil && prev.Type() == "comment"; prev = prev.PrevSibling() {
+		// only include comments which are directly adjacent to the declaration
+		if prev.EndPoint().Row+1 < start.StartPoint().Row {
+			break
+		}
+		// a comment trailing the code on its line belongs to that code
+		if before := prev.PrevNamedSibling(); before != nil && before.EndPoint().Row == prev.StartPoint().Row {
+			break
+		}
+		start = prev
+	}
+	return string(source[start.StartByte():t.EndByte()])
+}
+
+// signature returns the declaration of the function up to its body.
+func signature(t *sitter.Node, source []byte) string {
+	body := t.ChildByFieldName("body")
+	if body == nil {
+		return t.Content(source)
+	}
+	return strings.TrimSpace(string(source[t.StartByte():body.StartByte()]))
+}
+
+// takesTesting reports whether any of the function's parameters is a *testing.T or testing.TB.
+func takesTesting(t *sitter.Node, source []byte) bool {
+	params := t.ChildByFieldName("parameters")
+	if params == nil {
+		return false
+	}
+	for i := 0; i < int(params.NamedChildCount()); i++ {
+		typeNode := params.NamedChild(i).ChildByFieldName("type")
+		if typeNode == nil {
+			continue
+		}
+		switch typeNode.Content(source) {
+		case "*testing.T", "testing.TB":
+			return true
+		}
+	}
+	return false
+}
+
+// calledIdentifiers returns the distinct names of the functions called through plain identifiers within the node.
+func calledIdentifiers(t *sitter.Node, source []byte) []string {
+	seen := map[string]bool{}
+	calls := []string{}
+	iter := sitter.NewIterator(t, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() != "call_expression" {
+			return nil
+		}
+		function := node.ChildByFieldName("function")
+		if function == nil || function.Type() != "identifier" {
+			return nil
+		}
+		name := function.Content(source)
+		if !seen[name] {
+			seen[name] = true
+			calls = append(calls, name)
+		}
+		return nil
+	})
+	return calls
+}
+
+// calledNames returns the distinct names of the functions and methods called within the node,
+// whether called through a plain identifier or a selector. The names of methods are qualified by
+// the type of their receiver, where it can be told from the declarations of the node and of the struct fields.
+func calledNames(t *sitter.Node, source []byte, fields map[string]map[string]string) []string {
+	locals := localTypes(t, source, fields)
+	seen := map[string]bool{}
+	calls := []string{}
+	iter := sitter.NewIterator(t, sitter.DFSMode)
+	_ = iter.ForEach(func(node *sitter.Node) error {
+		if node.Type() != "call_expression" {
+			return nil
+		}
+		function := node.ChildByFieldName("function")
+		receiver := ""
+		if function != nil && function.Type() == "selector_expression" {
+			receiver = expressionType(function.ChildByFieldName("operand"), locals, fields, source)
+			function = function.ChildByFieldName("field")
+		}
+		if function == nil || (function.Type() != "identifier" && function.Type() != "field_identifier") {
+			return nil
+		}
+		name := QualifiedName(receiver, function.Content(source))
+		if !seen[name] {
+			seen[name] = true
+			calls = append(calls, name)
+		}
+		return nil
+	})
+	return calls
+}
+
+// ListPackageTestFunctions returns the functions declared in all of the test files within the package directory.
+func ListPackageTestFunctions(packageDir string) ([]TestFunction, error) {
+	files, err := filepath.Glob(filepath.Join(packageDir, "*_test.go"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list test files: %w", err)
+	}
+	functions := []TestFunction{}
+	for _, file := range files {
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read test file: %w", err)
+		}
+		fileFunctions, err := ListTestFunctions(file, code)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse test file %q: %w", file, err)
+		}
+		functions = append(functions, fileFunctions...)
+	}
+	return functions, nil
+}
+
+// SelectStyleExamples picks up to limit representative tests to show the model as examples.
+// Tests which use the package's test helpers are preferred, followed by tests calling the same
+// functions as the target, and shorter tests are preferred over longer ones.
+// Tests declared in the excluded file are skipped, since they are already part of the prompt.
+func SelectStyleExamples(functions []TestFunction, targetCalls []string, excludeFile string, limit int) []string {
+	helpers := map[string]bool{}
+	for _, function := range functions {
+		if !function.IsTest() {
+			helpers[function.Name] = true
+		}
+	}
+	related := map[string]bool{}
+	for _, call := range targetCalls {
+		related[call] = true
+	}
+
+	type candidate struct {
+		function TestFunction
+		score    int
+	}
+	candidates := []candidate{}
+	for _, function := range functions {
+		if !function.IsTest() || function.Filepath == excludeFile {
+			continue
+		}
+		score := 0
+		for _, call := range function.Calls {
+			if helpers[call] {
+				score += 2
+			}
+			if related[call] {
+				score++
+			}
+		}
+		candidates = append(candidates, candidate{function: function, score: score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return len(candidates[i].function.Source) < len(candidates[j].function.Source)
+	})
+
+	examples := []string{}
+	for i := 0; i < len(candidates) && i < limit; i++ {
+		examples = append(examples, candidates[i].function.Source)
+	}
+	return examples
+}
+
+// GetCalledNames returns the names of the functions called directly by the given function,
+// whose name may be qualified by its receiver type.
+func GetCalledNames(logger *slog.Logger, functionName string, code []byte) ([]string, error) {
+	parser := sitter.NewParser()
+	parser.SetLanguage(golang.GetLanguage())
+
+	tree, err := parser.ParseCtx(context.Background(), nil, code)
+	if tree == nil {
+		if err == nil {
+			err = fmt.Errorf("tree is nil")
+		}
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse code: %w", err)
+	}
+	defer tree.Close()
+
+	targetFunction, err := findFunction(logger, functionName, tree.RootNode(), code)
+	if err != nil {
+		return nil, fmt.Errorf("could not find function %q: %w", functionName, err)
+	}
+	if targetFunction == nil {
+		return nil, fmt.Errorf("could not find function %q", functionName)
+	}
+	_, name := SplitQualifiedName(functionName)
+	return append(calledIdentifiers(targetFunction, code), name), nil
+}
+
+// HasTest reports whether one of the test functions is named after the function,
+// following the conventions 'TestName', 'TestName_case', 'TestReceiver_Name', and 'TestReceiverName'.
+func HasTest(function types.FunctionInfo, tests []TestFunction) bool {
+	name := capitalize(function.Name)
+	candidates := []string{"Test" + name, "Test_" + function.Name}
+	if function.Receiver != "" {
+		receiver := capitalize(function.Receiver)
+		candidates = append(candidates, "Test"+receiver+"_"+name, "Test"+receiver+name, "Test"+receiver+"_"+function.Name)
+	}
+	for _, test := range tests {
+		for _, candidate := range candidates {
+			if test.Name == candidate || strings.HasPrefix(test.Name, candidate+"_") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func capitalize(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// IsReferenced reports whether any of the test functions calls the function or method with the given
+// receiver and name. A call whose receiver type cannot be told from the test only counts when the name is
+// unique, i.e. when no other function or method of the package has the same name.
+func IsReferenced(receiver string, name string, unique bool, tests []TestFunction) bool {
+	qualified := QualifiedName(receiver, name)
+	for _, test := range tests {
+		for _, reference := range test.References {
+			if reference == qualified || (reference == name && (receiver == "" || unique)) {
+				return true
+			}
+		}
+	}
+	return false
+}
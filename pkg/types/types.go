@@ -1,6 +1,9 @@
 package types
 
 import (
+	"log/slog"
+	"time"
+
 	sitter "github.com/smacker/go-tree-sitter"
 )
 
@@ -31,8 +34,130 @@ type Range struct {
 	End   sitter.Point
 }
 
+// TestCodePrompt holds the variables available to the prompt templates.
 type TestCodePrompt struct {
-	TargetFunction  string
+	// TargetFunction is the source of the function to be tested, including its doc comment.
+	TargetFunction string
+	// TypeParameters is the type parameter list of a generic target function, or of the receiver type of a method.
+	TypeParameters string
+	// Constraints are the definitions of the named constraints of the type parameters.
+	Constraints []string
+	// CalledFunctions are the definitions of the symbols referenced by the target function.
 	CalledFunctions []string
-	PackageName     string
+	// PackageName is the name of the package containing the target function.
+	PackageName string
+	// ExistingTests is the content of the test file the generated tests will be merged into.
+	ExistingTests string
+	// StyleExamples are representative tests from the package, shown as examples of its conventions.
+	StyleExamples []string
+	// TestHelpers are the signatures of the existing helpers which take a *testing.T or testing.TB.
+	TestHelpers []string
+	// Fixtures are the paths of the files in the package's testdata directory.
+	Fixtures []string
+	// TestMain is the source of the package's TestMain function, if it has one.
+	TestMain string
+	// Style describes the style the generated tests should follow.
+	Style string
+	// AssertionLibrary is the assertion library the generated tests should use.
+	AssertionLibrary string
+	// Instructions are additional instructions for the model.
+	Instructions string
+}
+
+// GenerationOptions configure the model used to generate test code.
+type GenerationOptions struct {
+	Provider string
+	Model    string
+	BaseURL  string
+	// MaxRetries is the number of times a failed request is retried.
+	MaxRetries int
+	// RequestTimeout limits each attempt of a request, or zero for no limit.
+	RequestTimeout time.Duration
+	// Logger, if set, receives the retries of failed requests.
+	Logger *slog.Logger
+	// Prices are the configured prices of models, which take precedence over the built-in ones.
+	Prices map[string]Price
+}
+
+// Price is the price of a model in US dollars per 1,000 tokens.
+type Price struct {
+	Prompt     float64 `yaml:"prompt" json:"prompt"`
+	Completion float64 `yaml:"completion" json:"completion"`
+}
+
+// RequestUsage is the number of tokens used by a single request to the model.
+type RequestUsage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	// Cached is true when the request was answered by the cache, without using any tokens.
+	Cached bool `json:"cached"`
+	// Estimated is true when the tokens were estimated, since streamed replies do not report their usage.
+	Estimated bool `json:"estimated,omitempty"`
+	// Cost is the estimated cost of the request in US dollars, or zero when the price of the model is unknown.
+	Cost float64 `json:"cost"`
+}
+
+// FunctionInfo describes a top-level function or method declaration within a file.
+type FunctionInfo struct {
+	Name       string
+	Receiver   string
+	Range      Range
+	Complexity int
+}
+
+// Definition is the source of a symbol referenced by the target function.
+type Definition struct {
+	Name     string `json:"name"`
+	Filepath string `json:"filepath"`
+	// Line and Column are the one-indexed position of the symbol's definition.
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Source string `json:"-"`
+}
+
+// Verification statuses of a generated test file.
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Verification is the result of compiling and running the generated tests.
+type Verification struct {
+	Compile string `json:"compile"`
+	Test    string `json:"test"`
+	Output  string `json:"output,omitempty"`
+}
+
+// GenerationResult describes the outcome of generating a test, for consumption by other tools.
+type GenerationResult struct {
+	TargetFunction   string       `json:"targetFunction"`
+	Filepath         string       `json:"filepath"`
+	Definitions      []Definition `json:"definitions"`
+	Model            string       `json:"model"`
+	PromptTokens     int          `json:"promptTokens"`
+	CompletionTokens int          `json:"completionTokens"`
+	// CacheHits is the number of responses answered by the cache, which used no tokens.
+	CacheHits int `json:"cacheHits"`
+	// Requests are the requests sent to the model, in the order they were sent.
+	Requests []RequestUsage `json:"requests"`
+	// Cost is the estimated cost of all requests in US dollars, or zero when the price of the model is unknown.
+	Cost       float64 `json:"cost"`
+	OutputPath string  `json:"outputPath"`
+	// Output is the printed test file or diff, for dry runs and output to stdout.
+	Output       string       `json:"output,omitempty"`
+	Verification Verification `json:"verification"`
+	Errors       []string     `json:"errors"`
+}
+
+// Position is a one-indexed position within a file. A Column of zero stands for the whole line.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// LineRange is an inclusive range of one-indexed lines within a file.
+type LineRange struct {
+	Start int
+	End   int
 }